@@ -1,7 +1,12 @@
 package config
 
 import (
+	"errors"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -9,14 +14,223 @@ type Config struct {
 	DatabaseURL   string
 	JWTSecret     string
 	MongoDatabase string
+	FrontendURL   string
+	SiteTitle     string
+	// PublicAPIURL is the address of this API as seen from outside, used
+	// in links that point straight at it rather than at the frontend.
+	PublicAPIURL string
+	// TokenSecret signs the tokens in emailed links. It has no default:
+	// anyone who knows it can forge invitations and email changes.
+	TokenSecret string
+
+	// RobotsDisallowAll blocks every crawler, e.g. on staging.
+	RobotsDisallowAll bool
+	RobotsDisallow    []string
+
+	// Trashed documents are purged once they are older than TrashRetention.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// ReactionTypes is the set of emoji reactions readers may leave.
+	ReactionTypes []string
+	// AnonymousReactions lets signed-out readers react, throttled per IP.
+	AnonymousReactions      bool
+	AnonymousReactionLimit  int
+	AnonymousReactionWindow time.Duration
+
+	// ViewFlushInterval is how often buffered post views are written to Mongo.
+	ViewFlushInterval time.Duration
+
+	// CommentMaxDepth is how deeply replies may nest; top-level comments are depth 0.
+	CommentMaxDepth int
+	// TrustedCommenterThreshold is how many approved comments a commenter
+	// needs before their comments skip the moderation queue. 0 disables it.
+	TrustedCommenterThreshold int64
+	// CommentEditWindow is how long authors may edit or delete their own
+	// comments. 0 means there is no limit.
+	CommentEditWindow time.Duration
+
+	// At most MentionNotifyLimit mention notifications are sent from, and
+	// to, any one user per MentionNotifyWindow.
+	MentionNotifyLimit  int
+	MentionNotifyWindow time.Duration
+
+	// MailBackend is one of sendgrid, smtp, file or memory. The file backend
+	// writes a maildir under MailDir for development.
+	MailBackend    string
+	MailFrom       string
+	MailDir        string
+	SendGridAPIKey string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPRequireTLS bool
+
+	// Bounces and complaints are accepted from providers whose webhook
+	// signing key is set.
+	SendGridWebhookKey string
+	MailWebhookSecret  string
+
+	// EmailTemplateDir holds templates that replace the built-in ones.
+	// DefaultLocale is used for users who haven't chosen a language.
+	EmailTemplateDir string
+	DefaultLocale    string
+
+	// Email is delivered from the outbox by EmailWorkers workers. Failed
+	// sends are retried after EmailRetryBackoff, doubling up to
+	// EmailMaxBackoff, and dead-lettered after EmailMaxAttempts.
+	EmailWorkers      int
+	EmailMaxAttempts  int
+	EmailRetryBackoff time.Duration
+	EmailMaxBackoff   time.Duration
+	EmailPollInterval time.Duration
+
+	// Anonymous subscribers may sign up SubscribeLimit times per
+	// SubscribeWindow from one IP. Digest subscriptions are checked every
+	// DigestCheckInterval.
+	SubscribeLimit      int
+	SubscribeWindow     time.Duration
+	DigestCheckInterval time.Duration
+
+	// Invitations last InvitationTTL unless the inviter picks another expiry.
+	InvitationTTL time.Duration
+
+	// Users whose role request was denied must wait RoleRequestCooldown
+	// before asking again.
+	RoleRequestCooldown time.Duration
+
+	// Live update streams send a heartbeat every StreamHeartbeat, remember
+	// StreamHistorySize events for resuming and drop subscribers that fall
+	// StreamBufferSize events behind.
+	StreamHeartbeat   time.Duration
+	StreamHistorySize int
+	StreamBufferSize  int
+
+	// Limits on the WebSocket endpoint. 0 disables a limit. Each user may
+	// send SocketMessageLimit messages per SocketMessageWindow.
+	SocketMaxConnections        int
+	SocketMaxConnectionsPerUser int
+	SocketMaxSubscriptions      int
+	SocketMaxMessageSize        int64
+	SocketMessageLimit          int
+	SocketMessageWindow         time.Duration
+	SocketPingInterval          time.Duration
+	SocketPresenceTimeout       time.Duration
+
+	// Each spam checker's 0-1 score is multiplied by its weight and summed.
+	// Comments reaching SpamHoldThreshold are held for review and those
+	// reaching SpamRejectThreshold are marked as spam. 0 disables a threshold.
+	SpamHoldThreshold    float64
+	SpamRejectThreshold  float64
+	SpamMaxLinks         int
+	SpamBlockedDomains   []string
+	SpamMinSubmitTime    time.Duration
+	SpamWeightLinks      float64
+	SpamWeightBayes      float64
+	SpamWeightHoneypot   float64
+	SpamWeightSubmitTime float64
+}
+
+// defaultJWTSecret is the placeholder JWT_SECRET falls back to. It is
+// public, so it must never sign anything else.
+const defaultJWTSecret = "your-secret-key"
+
+var ErrTokenSecret = errors.New("TOKEN_SECRET must be set to a secret of its own")
+
+// Validate reports settings the server must not start with.
+func (c *Config) Validate() error {
+	if c.TokenSecret == "" || c.TokenSecret == defaultJWTSecret {
+		return ErrTokenSecret
+	}
+	return nil
 }
 
 func Load() *Config {
+	port := getEnv("PORT", "3003")
+	jwtSecret := getEnv("JWT_SECRET", defaultJWTSecret)
 	return &Config{
-		Port:          getEnv("PORT", "3003"),
+		Port:          port,
 		DatabaseURL:   getEnv("DATABASE_URL", "mongodb://localhost:27017"),
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key"),
+		JWTSecret:     jwtSecret,
 		MongoDatabase: getEnv("MONGO_DATABASE", "edandlinda"),
+		FrontendURL:   getEnv("FRONTEND_URL", "http://localhost:3001"),
+		SiteTitle:     getEnv("SITE_TITLE", "Ed and Linda"),
+		PublicAPIURL:  getEnv("PUBLIC_API_URL", "http://localhost:"+port),
+		TokenSecret:   getEnv("TOKEN_SECRET", ""),
+
+		RobotsDisallowAll: getEnvBool("ROBOTS_DISALLOW_ALL", false),
+		RobotsDisallow:    getEnvList("ROBOTS_DISALLOW", "/api/"),
+
+		TrashRetention:     time.Duration(getEnvInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		TrashPurgeInterval: getEnvInterval("TRASH_PURGE_INTERVAL", time.Hour),
+
+		ReactionTypes:           getEnvList("REACTION_TYPES", "like,love,laugh,wow,sad"),
+		AnonymousReactions:      getEnvBool("ANONYMOUS_REACTIONS", false),
+		AnonymousReactionLimit:  getEnvInt("ANONYMOUS_REACTION_LIMIT", 30),
+		AnonymousReactionWindow: getEnvDuration("ANONYMOUS_REACTION_WINDOW", time.Minute),
+
+		ViewFlushInterval: getEnvInterval("VIEW_FLUSH_INTERVAL", 30*time.Second),
+
+		CommentMaxDepth:           getEnvInt("COMMENT_MAX_DEPTH", 5),
+		TrustedCommenterThreshold: int64(getEnvInt("TRUSTED_COMMENTER_THRESHOLD", 3)),
+		CommentEditWindow:         getEnvDuration("COMMENT_EDIT_WINDOW", 15*time.Minute),
+
+		MentionNotifyLimit:  getEnvInt("MENTION_NOTIFY_LIMIT", 10),
+		MentionNotifyWindow: getEnvDuration("MENTION_NOTIFY_WINDOW", time.Hour),
+
+		MailBackend:    getEnv("MAIL_BACKEND", "sendgrid"),
+		MailFrom:       getEnv("MAIL_FROM", os.Getenv("SENDGRID_FROM_EMAIL")),
+		MailDir:        getEnv("MAIL_DIR", "./mail"),
+		SendGridAPIKey: getEnv("SENDGRID_API_KEY", ""),
+		SMTPHost:       getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:       getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		SMTPRequireTLS: getEnvBool("SMTP_REQUIRE_TLS", false),
+
+		SendGridWebhookKey: getEnv("SENDGRID_WEBHOOK_PUBLIC_KEY", ""),
+		MailWebhookSecret:  getEnv("MAIL_WEBHOOK_SECRET", ""),
+
+		EmailTemplateDir: getEnv("EMAIL_TEMPLATE_DIR", ""),
+		DefaultLocale:    getEnv("DEFAULT_LOCALE", "en"),
+
+		EmailWorkers:      getEnvCount("EMAIL_WORKERS", 2),
+		EmailMaxAttempts:  getEnvCount("EMAIL_MAX_ATTEMPTS", 8),
+		EmailRetryBackoff: getEnvDuration("EMAIL_RETRY_BACKOFF", 30*time.Second),
+		EmailMaxBackoff:   getEnvDuration("EMAIL_MAX_BACKOFF", 6*time.Hour),
+		EmailPollInterval: getEnvInterval("EMAIL_POLL_INTERVAL", 10*time.Second),
+
+		SubscribeLimit:      getEnvInt("SUBSCRIBE_LIMIT", 5),
+		SubscribeWindow:     getEnvDuration("SUBSCRIBE_WINDOW", time.Hour),
+		DigestCheckInterval: getEnvInterval("DIGEST_CHECK_INTERVAL", time.Hour),
+
+		InvitationTTL: getEnvDuration("INVITATION_TTL", 7*24*time.Hour),
+
+		RoleRequestCooldown: getEnvInterval("ROLE_REQUEST_COOLDOWN", 7*24*time.Hour),
+
+		StreamHeartbeat:   getEnvInterval("STREAM_HEARTBEAT", 15*time.Second),
+		StreamHistorySize: getEnvInt("STREAM_HISTORY_SIZE", 1000),
+		StreamBufferSize:  getEnvInt("STREAM_BUFFER_SIZE", 64),
+
+		SocketMaxConnections:        getEnvInt("SOCKET_MAX_CONNECTIONS", 1000),
+		SocketMaxConnectionsPerUser: getEnvInt("SOCKET_MAX_CONNECTIONS_PER_USER", 5),
+		SocketMaxSubscriptions:      getEnvInt("SOCKET_MAX_SUBSCRIPTIONS", 20),
+		SocketMaxMessageSize:        int64(getEnvInt("SOCKET_MAX_MESSAGE_SIZE", 4096)),
+		SocketMessageLimit:          getEnvInt("SOCKET_MESSAGE_LIMIT", 60),
+		SocketMessageWindow:         getEnvDuration("SOCKET_MESSAGE_WINDOW", time.Minute),
+		SocketPingInterval:          getEnvInterval("SOCKET_PING_INTERVAL", 25*time.Second),
+		SocketPresenceTimeout:       getEnvInterval("SOCKET_PRESENCE_TIMEOUT", time.Minute),
+
+		SpamHoldThreshold:    getEnvFloat("SPAM_HOLD_THRESHOLD", 0.5),
+		SpamRejectThreshold:  getEnvFloat("SPAM_REJECT_THRESHOLD", 1.0),
+		SpamMaxLinks:         getEnvInt("SPAM_MAX_LINKS", 2),
+		SpamBlockedDomains:   getEnvList("SPAM_BLOCKED_DOMAINS", ""),
+		SpamMinSubmitTime:    getEnvDuration("SPAM_MIN_SUBMIT_TIME", 3*time.Second),
+		SpamWeightLinks:      getEnvFloat("SPAM_WEIGHT_LINKS", 0.6),
+		SpamWeightBayes:      getEnvFloat("SPAM_WEIGHT_BAYES", 0.8),
+		SpamWeightHoneypot:   getEnvFloat("SPAM_WEIGHT_HONEYPOT", 1.0),
+		SpamWeightSubmitTime: getEnvFloat("SPAM_WEIGHT_SUBMIT_TIME", 0.5),
 	}
 }
 
@@ -26,3 +240,63 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration reads a Go duration string such as "90s" or "1h30m".
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvInterval reads a duration that must be positive, such as one that
+// drives a timer; anything else falls back to the default.
+func getEnvInterval(key string, defaultValue time.Duration) time.Duration {
+	if value := getEnvDuration(key, defaultValue); value > 0 {
+		return value
+	}
+	log.Printf("%s must be positive; using %v", key, defaultValue)
+	return defaultValue
+}
+
+// getEnvCount reads a number of things there must be at least one of;
+// anything else falls back to the default.
+func getEnvCount(key string, defaultValue int) int {
+	if value := getEnvInt(key, defaultValue); value > 0 {
+		return value
+	}
+	log.Printf("%s must be at least 1; using %d", key, defaultValue)
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list, dropping empty entries.
+func getEnvList(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -12,6 +13,10 @@ import (
 // MongoClient is the exported client instance
 var MongoClient *mongo.Client
 
+// SupportsTransactions reports whether the deployment is a replica set or
+// sharded cluster. Standalone servers reject multi-document transactions.
+var SupportsTransactions bool
+
 // InitMongo initializes the MongoDB client and returns an error if it fails.
 // Pass the MongoDB URI as the argument.
 func InitMongo(uri string) error {
@@ -31,6 +36,20 @@ func InitMongo(uri string) error {
 	}
 
 	MongoClient = client
+	SupportsTransactions = detectTransactionSupport(ctx, client)
 	fmt.Println("Connected to MongoDB successfully.")
 	return nil
 }
+
+func detectTransactionSupport(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		fmt.Println("Could not determine MongoDB topology, transactions disabled:", err)
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
@@ -0,0 +1,29 @@
+// Package feeds renders blog listings as RSS 2.0, Atom and JSON Feed documents.
+package feeds
+
+import (
+	"time"
+)
+
+// Feed is the format-neutral description of a syndication feed.
+type Feed struct {
+	Title       string
+	Description string
+	SiteURL     string
+	FeedURL     string
+	Updated     time.Time
+	Items       []Item
+}
+
+// Item is a single entry in a feed.
+type Item struct {
+	ID          string
+	Title       string
+	URL         string
+	Author      string
+	Category    string
+	Summary     string
+	ContentHTML string
+	Published   time.Time
+	Updated     time.Time
+}
@@ -0,0 +1,175 @@
+package feeds
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags maps the elements kept by SanitizeHTML to the attributes they
+// may carry. Everything else is dropped while its text content is kept.
+var allowedTags = map[string][]string{
+	"a": {"href", "title"}, "abbr": {"title"}, "b": nil, "blockquote": nil,
+	"br": nil, "code": nil, "em": nil, "figcaption": nil, "figure": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"hr": nil, "i": nil, "img": {"src", "alt", "title"}, "li": nil,
+	"ol": nil, "p": nil, "pre": nil, "s": nil, "strong": nil, "sub": nil,
+	"sup": nil, "u": nil, "ul": nil,
+}
+
+// droppedTags are removed together with everything inside them.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"embed": true, "noscript": true, "template": true, "form": true,
+}
+
+var urlAttributes = map[string]bool{"href": true, "src": true}
+
+// SanitizeHTML reduces body to a safe subset of HTML suitable for feed
+// readers. Relative links and images are resolved against baseURL.
+func SanitizeHTML(body, baseURL string) string {
+	base, _ := url.Parse(baseURL)
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+
+	var out bytes.Buffer
+	skipDepth := 0
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			// io.EOF or malformed input; either way keep what we have.
+			return out.String()
+		}
+
+		token := tokenizer.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[token.Data] {
+				if tt == html.StartTagToken {
+					skipDepth++
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			allowed, ok := allowedTags[token.Data]
+			if !ok {
+				continue
+			}
+			token.Attr = filterAttributes(token.Attr, allowed, base)
+			if token.Data == "img" && len(token.Attr) == 0 {
+				continue
+			}
+			out.WriteString(token.String())
+		case html.EndTagToken:
+			if droppedTags[token.Data] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if _, ok := allowedTags[token.Data]; ok {
+				out.WriteString(token.String())
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+}
+
+func filterAttributes(attrs []html.Attribute, allowed []string, base *url.URL) []html.Attribute {
+	var kept []html.Attribute
+	for _, attr := range attrs {
+		if !contains(allowed, attr.Key) {
+			continue
+		}
+		if urlAttributes[attr.Key] {
+			resolved, ok := safeURL(attr.Val, base)
+			if !ok {
+				continue
+			}
+			attr.Val = resolved
+		}
+		kept = append(kept, attr)
+	}
+	return kept
+}
+
+func safeURL(raw string, base *url.URL) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return u.String(), true
+	}
+	return "", false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Summarize strips all markup from body and returns at most maxLen runes of
+// its text, cut at a word boundary.
+func Summarize(body string, maxLen int) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+
+	var text strings.Builder
+	skipDepth := 0
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tt {
+		case html.StartTagToken:
+			if droppedTags[token.Data] {
+				skipDepth++
+			}
+			text.WriteByte(' ')
+		case html.EndTagToken:
+			if droppedTags[token.Data] && skipDepth > 0 {
+				skipDepth--
+			}
+			text.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			text.WriteByte(' ')
+		case html.TextToken:
+			if skipDepth == 0 {
+				text.WriteString(token.Data)
+			}
+		}
+	}
+
+	words := strings.FieldsFunc(text.String(), unicode.IsSpace)
+	summary := strings.Join(words, " ")
+	runes := []rune(summary)
+	if len(runes) <= maxLen {
+		return summary
+	}
+
+	cut := string(runes[:maxLen])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
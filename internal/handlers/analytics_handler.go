@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	analyticsDefaultDays = 30
+	analyticsMaxDays     = 366
+)
+
+type AnalyticsHandler struct{}
+
+func NewAnalyticsHandler() *AnalyticsHandler {
+	return &AnalyticsHandler{}
+}
+
+// Post returns view statistics for one post. Only the post's author and
+// Admins may see them.
+func (h *AnalyticsHandler) Post(c *gin.Context) {
+	from, to, ok := analyticsRange(c)
+	if !ok {
+		return
+	}
+
+	blog, err := services.GetBlogByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if blog == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
+		return
+	}
+	if currentRole(c) != "Admin" && !services.IsBlogOwner(blog, currentUserID(c), currentUserName(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	stats, err := services.GetBlogViewStats(blog.ID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blog_id": blog.ID, "view_count": blog.ViewCount, "stats": stats})
+}
+
+// Author returns view statistics across the caller's own posts.
+func (h *AnalyticsHandler) Author(c *gin.Context) {
+	from, to, ok := analyticsRange(c)
+	if !ok {
+		return
+	}
+
+	stats, err := services.GetAuthorViewStats(currentUserID(c), currentUserName(c), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// Site returns site-wide totals and view statistics.
+func (h *AnalyticsHandler) Site(c *gin.Context) {
+	from, to, ok := analyticsRange(c)
+	if !ok {
+		return
+	}
+
+	totals, err := services.GetSiteTotals()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stats, err := services.GetSiteViewStats(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"totals": totals, "stats": stats})
+}
+
+// analyticsRange reads the from/to query parameters (YYYY-MM-DD, inclusive),
+// defaulting to the last 30 days.
+func analyticsRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date formatted as YYYY-MM-DD"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -(analyticsDefaultDays - 1))
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date formatted as YYYY-MM-DD"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+
+	if from.After(to) || to.Sub(from) > analyticsMaxDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range must be between 1 and 366 days"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 200
+)
+
+type AuditHandler struct{}
+
+func NewAuditHandler() *AuditHandler {
+	return &AuditHandler{}
+}
+
+// List shows the audit log, newest first, optionally narrowed with the
+// action, actor_id and target_id query parameters.
+func (h *AuditHandler) List(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(auditDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > auditMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+
+	entries, err := services.GetAuditLog(services.AuditFilter{
+		Action:   c.Query("action"),
+		ActorID:  c.Query("actor_id"),
+		TargetID: c.Query("target_id"),
+	}, limit, (page-1)*limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AuthHandler struct{}
@@ -35,6 +37,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	userRole := user.Role
 	claims := jwt.MapClaims{
+		"sub":       user.ID.Hex(),
 		"user_name": user.UserName,
 		"user":      user.ID,
 		"role":      userRole,
@@ -71,3 +74,58 @@ func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
 	// TODO: Resend verification email
 	c.JSON(http.StatusOK, gin.H{"message": "Resend verification endpoint"})
 }
+
+// RequestEmailChange sends a confirmation link to the address the caller
+// wants to change to.
+func (h *AuthHandler) RequestEmailChange(c *gin.Context) {
+	var req struct {
+		NewEmail string `json:"new_email" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := services.RequestEmailChange(currentUserID(c), req.NewEmail, req.Password)
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrEmailUnchanged):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Check your new address for a link to confirm the change"})
+	}
+}
+
+// ConfirmEmailChange applies an email change from the link sent to the new
+// address.
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.ConfirmEmailChange(req.Token)
+	switch {
+	case errors.Is(err, services.ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	case user == nil:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Email address changed", "user_email": user.UserEmail})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	authorPostsDefaultLimit = 10
+	authorPostsMaxLimit     = 50
+)
+
+type AuthorHandler struct{}
+
+func NewAuthorHandler() *AuthorHandler {
+	return &AuthorHandler{}
+}
+
+// Get returns an author's public profile, stats and a page of their posts.
+func (h *AuthorHandler) Get(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(authorPostsDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > authorPostsMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+		return
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+
+	author, err := services.GetAuthorPage(c.Param("username"), limit, (page-1)*limit)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Author not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, author)
+}
+
+// LinkPosts links existing posts to their authors' accounts by email.
+func (h *AuthorHandler) LinkPosts(c *gin.Context) {
+	result, err := services.LinkBlogAuthors()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
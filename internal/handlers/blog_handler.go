@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type BlogHandler struct{}
@@ -34,6 +35,7 @@ func (h *BlogHandler) GetByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
 		return
 	}
+	services.RecordView(blog.ID, c.ClientIP(), c.Request.UserAgent(), c.Request.Referer())
 	c.JSON(http.StatusOK, blog)
 }
 
@@ -43,6 +45,18 @@ func (h *BlogHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// New posts belong to the caller's account, under the caller's name.
+	// An update keeps the post's byline.
+	if blog.ID.IsZero() {
+		author, err := services.GetUserByID(currentUserID(c))
+		if err != nil || author == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		blog.AuthorID = &author.ID
+		blog.OwnerName = author.UserName
+		blog.OwnerEmail = author.UserEmail
+	}
 
 	id, err := services.SaveBlog(&blog)
 	if err != nil {
@@ -58,13 +72,18 @@ func (h *BlogHandler) Create(c *gin.Context) {
 
 func (h *BlogHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
-	err := services.DeleteBlog(id)
+	affected, err := services.DeleteBlog(id, currentUserID(c))
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Blog deleted successfully",
-		"id":      id,
+		"message":  "Blog deleted successfully",
+		"id":       id,
+		"affected": affected,
 	})
 }
@@ -1,39 +1,108 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"goserver/internal/config"
 	"goserver/internal/models"
 	"goserver/internal/services"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-type CommentHandler struct{}
+const (
+	commentDefaultLimit = 50
+	commentMaxLimit     = 200
+)
+
+type CommentHandler struct {
+	cfg *config.Config
+}
 
-func NewCommentHandler() *CommentHandler {
-	return &CommentHandler{}
+func NewCommentHandler(cfg *config.Config) *CommentHandler {
+	return &CommentHandler{cfg: cfg}
 }
 
+// GetByBlogID lists a page of a blog's comments, or a nested tree of
+// replies with ?view=tree. ?sort= orders top-level comments by oldest,
+// newest or reactions, ?limit= sets how many come per page and ?since=
+// (RFC 3339) returns only newer comments. When there are more comments the
+// X-Next-Cursor header holds the ?cursor= for the next page.
 func (h *CommentHandler) GetByBlogID(c *gin.Context) {
 	blogID := c.Param("blogId")
-	comments, err := services.GetCommentsByBlogID(blogID)
+
+	opts := services.CommentPageOptions{
+		Sort:   c.DefaultQuery("sort", services.CommentSortOldest),
+		Cursor: c.Query("cursor"),
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(commentDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > commentMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	opts.Limit = limit
+	if value := c.Query("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 timestamp"})
+			return
+		}
+		opts.Since = &since
+	}
+
+	comments, next, err := services.GetCommentsByBlogID(blogID, opts)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) || errors.Is(err, services.ErrUnknownCommentSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+	if c.Query("view") == "tree" {
+		c.JSON(http.StatusOK, services.BuildCommentTree(comments))
+		return
+	}
 	c.JSON(http.StatusOK, comments)
 }
 
+// FormToken issues the token a comment form for the blog sends back with
+// the comment, so that the time taken to write it can be checked.
+func (h *CommentHandler) FormToken(c *gin.Context) {
+	blogID := c.Param("blogId")
+	if _, err := primitive.ObjectIDFromHex(blogID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blog ID"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"form_token": services.IssueCommentFormToken(blogID)})
+}
+
 func (h *CommentHandler) Create(c *gin.Context) {
 	blogID := c.Param("blogId")
 
-	var comment models.Comment
-	if err := c.ShouldBindJSON(&comment); err != nil {
+	// website is a honeypot the comment form hides from people, and
+	// form_token is the token from FormToken, saying when the form was shown.
+	var req struct {
+		models.Comment
+		Website   string `json:"website"`
+		FormToken string `json:"form_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	comment := req.Comment
+
+	elapsed, formTimed := services.CommentFormElapsed(req.FormToken, blogID)
 
 	// Set the BlogID field (assuming it's an ObjectID in your model)
 	objID, err := primitive.ObjectIDFromHex(blogID)
@@ -42,37 +111,72 @@ func (h *CommentHandler) Create(c *gin.Context) {
 		return
 	}
 	comment.BlogID = objID
-
-	id, err := services.AddComment(&comment)
+	comment.CommenterID = currentUserID(c)
+
+	id, err := services.AddComment(&comment, services.AddCommentOptions{
+		MaxDepth:         h.cfg.CommentMaxDepth,
+		UserID:           currentUserID(c),
+		UserName:         currentUserName(c),
+		Role:             currentRole(c),
+		TrustedThreshold: h.cfg.TrustedCommenterThreshold,
+
+		SpamHoldThreshold:   h.cfg.SpamHoldThreshold,
+		SpamRejectThreshold: h.cfg.SpamRejectThreshold,
+		Honeypot:            req.Website,
+		Elapsed:             elapsed,
+		FormTimed:           formTimed,
+	})
 	if err != nil {
+		if errors.Is(err, services.ErrBlogNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
+			return
+		}
+		if errors.Is(err, services.ErrParentNotFound) || errors.Is(err, services.ErrMaxDepth) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if comment.Status == models.CommentStatusSpam {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Comment was rejected as spam"})
+		return
+	}
+
+	message := "Comment created successfully"
+	if comment.Status == models.CommentStatusPending {
+		message = "Comment submitted and waiting for review"
+	}
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Comment created successfully",
+		"message": message,
 		"id":      id.Hex(),
 		"blogId":  blogID,
+		"status":  comment.Status,
 	})
 }
 
+// Update edits a comment's body. Only the fields in services.CommentUpdate
+// are accepted; anything else in the payload is rejected.
 func (h *CommentHandler) Update(c *gin.Context) {
 	blogID := c.Param("blogId")
 	id := c.Param("id")
 
-	var updateData map[string]interface{}
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	var update services.CommentUpdate
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := services.UpdateComment(blogID, id, updateData)
+	err := services.UpdateComment(blogID, id, update, commentActor(c), services.CommentEditOptions{
+		EditWindow:          h.cfg.CommentEditWindow,
+		SpamHoldThreshold:   h.cfg.SpamHoldThreshold,
+		SpamRejectThreshold: h.cfg.SpamRejectThreshold,
+	})
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondCommentChangeError(c, err)
 		return
 	}
 
@@ -87,13 +191,9 @@ func (h *CommentHandler) Delete(c *gin.Context) {
 	blogID := c.Param("blogId")
 	id := c.Param("id")
 
-	err := services.DeleteComment(blogID, id)
+	err := services.DeleteComment(blogID, id, commentActor(c), h.cfg.CommentEditWindow)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondCommentChangeError(c, err)
 		return
 	}
 
@@ -103,3 +203,20 @@ func (h *CommentHandler) Delete(c *gin.Context) {
 		"id":      id,
 	})
 }
+
+// respondCommentChangeError maps an UpdateComment or DeleteComment error to
+// a response.
+func respondCommentChangeError(c *gin.Context, err error) {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+	case errors.Is(err, services.ErrCommentForbidden), errors.Is(err, services.ErrEditWindowClosed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmptyComment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCommentEditConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
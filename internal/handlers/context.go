@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserID returns the ID of the authenticated caller, or "" when the
+// request did not pass through RequireAuth.
+func currentUserID(c *gin.Context) string {
+	return c.GetString("userID")
+}
+
+// currentUserName returns the user name of the authenticated caller.
+func currentUserName(c *gin.Context) string {
+	return c.GetString("userName")
+}
+
+// currentRole returns the role of the authenticated caller.
+func currentRole(c *gin.Context) string {
+	return c.GetString("roles")
+}
+
+// commentActor identifies the authenticated caller to the comment services.
+func commentActor(c *gin.Context) services.CommentActor {
+	return services.CommentActor{
+		UserID:   currentUserID(c),
+		UserName: currentUserName(c),
+		Role:     currentRole(c),
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/services"
+	"goserver/internal/templates"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailTemplateHandler struct{}
+
+func NewEmailTemplateHandler() *EmailTemplateHandler {
+	return &EmailTemplateHandler{}
+}
+
+// List shows the email templates and the locales each is translated into.
+func (h *EmailTemplateHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, services.ListEmailTemplates())
+}
+
+// Preview renders a template with sample data. A POST body of JSON values
+// replaces parts of the sample, and ?format=html returns the HTML body
+// itself so it can be viewed in a browser.
+func (h *EmailTemplateHandler) Preview(c *gin.Context) {
+	var data templates.Data
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	email, err := services.PreviewEmail(c.Param("name"), c.Query("locale"), data)
+	if err != nil {
+		if errors.Is(err, templates.ErrUnknownTemplate) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "html" {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(email.HTML))
+		return
+	}
+	c.JSON(http.StatusOK, email)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/feeds"
+	"goserver/internal/mentions"
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	feedItemLimit  = 50
+	feedSummaryLen = 300
+)
+
+type FeedHandler struct {
+	cfg *config.Config
+}
+
+func NewFeedHandler(cfg *config.Config) *FeedHandler {
+	return &FeedHandler{cfg: cfg}
+}
+
+func (h *FeedHandler) RSS(c *gin.Context) {
+	h.serve(c, "rss", "application/rss+xml; charset=utf-8", feeds.RSS)
+}
+
+func (h *FeedHandler) Atom(c *gin.Context) {
+	h.serve(c, "atom", "application/atom+xml; charset=utf-8", feeds.Atom)
+}
+
+func (h *FeedHandler) JSON(c *gin.Context) {
+	h.serve(c, "json", "application/feed+json; charset=utf-8", feeds.JSONFeed)
+}
+
+func (h *FeedHandler) serve(c *gin.Context, format, contentType string, render func(*feeds.Feed) ([]byte, error)) {
+	filter := services.BlogFilter{
+		Category: c.Param("category"),
+		Author:   c.Param("author"),
+	}
+
+	// Answer polling feed readers from the collection summary before loading any posts.
+	state, err := services.GetBlogCollectionState(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	etag := collectionETag(format+"|"+filter.Category+"|"+filter.Author, state)
+	if notModified(c, etag, state.LastModified) {
+		return
+	}
+
+	blogs, err := services.GetRecentBlogs(filter, feedItemLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	siteURL := strings.TrimRight(h.cfg.FrontendURL, "/")
+	feed := &feeds.Feed{
+		Title:       h.feedTitle(filter),
+		Description: h.feedTitle(filter),
+		SiteURL:     siteURL,
+		FeedURL:     requestBaseURL(c) + c.Request.URL.Path,
+		Updated:     state.LastModified,
+	}
+	for _, blog := range blogs {
+		updated := blog.UpdatedAt
+		if updated.IsZero() {
+			updated = blog.CreatedAt
+		}
+		postURL := fmt.Sprintf("%s/blog/%s", siteURL, blog.ID.Hex())
+		feed.Items = append(feed.Items, feeds.Item{
+			ID:          postURL,
+			Title:       blog.Subject,
+			URL:         postURL,
+			Author:      blog.OwnerName,
+			Category:    blog.Category,
+			Summary:     feeds.Summarize(blog.Body, feedSummaryLen),
+			ContentHTML: feeds.SanitizeHTML(mentions.Link(blog.Body, mentionProfiles(siteURL, blog.Mentions)), siteURL),
+			Published:   blog.CreatedAt,
+			Updated:     updated,
+		})
+	}
+
+	body, err := render(feed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+func (h *FeedHandler) feedTitle(filter services.BlogFilter) string {
+	switch {
+	case filter.Category != "":
+		return fmt.Sprintf("%s: %s", h.cfg.SiteTitle, filter.Category)
+	case filter.Author != "":
+		return fmt.Sprintf("%s: posts by %s", h.cfg.SiteTitle, filter.Author)
+	}
+	return h.cfg.SiteTitle
+}
+
+// collectionETag derives a validator from everything that changes a listing:
+// adding or removing a post changes the count, editing one changes the date.
+func collectionETag(key string, state *services.BlogCollectionState) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", key, state.Count, state.LastModified.UnixNano())))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the caching validators on the response and, when the
+// request's conditional headers match, aborts with 304 Not Modified.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=300")
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				c.Status(http.StatusNotModified)
+				c.Abort()
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(since); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			c.Status(http.StatusNotModified)
+			c.Abort()
+			return true
+		}
+	}
+	return false
+}
+
+// requestBaseURL returns the scheme and host the client used to reach us.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// mentionProfiles maps each mentioned user name, lowercased, to the user's
+// author page.
+func mentionProfiles(siteURL string, mentioned []models.Mention) map[string]string {
+	profiles := make(map[string]string, len(mentioned))
+	for _, mention := range mentioned {
+		profiles[strings.ToLower(mention.UserName)] = siteURL + "/author/" + url.PathEscape(mention.UserName)
+	}
+	return profiles
+}
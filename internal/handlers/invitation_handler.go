@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	invitationDefaultLimit = 50
+	invitationMaxLimit     = 200
+)
+
+type InvitationHandler struct {
+	ttl time.Duration
+}
+
+func NewInvitationHandler(cfg *config.Config) *InvitationHandler {
+	return &InvitationHandler{ttl: cfg.InvitationTTL}
+}
+
+// expiresIn is how long an invitation should last: expires_in_hours if the
+// inviter gave it, otherwise the configured default.
+func (h *InvitationHandler) expiresIn(hours int) time.Duration {
+	if hours == 0 {
+		return h.ttl
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Create invites someone by email with a role no higher than the caller's.
+func (h *InvitationHandler) Create(c *gin.Context) {
+	var req struct {
+		Email          string `json:"email" binding:"required"`
+		Role           string `json:"role" binding:"required"`
+		Message        string `json:"message"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := services.CreateInvitation(services.InvitationRequest{
+		Email:     req.Email,
+		Role:      req.Role,
+		Message:   req.Message,
+		ExpiresIn: h.expiresIn(req.ExpiresInHours),
+	}, commentActor(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// List shows every invitation, newest first. The status query parameter
+// narrows the list.
+func (h *InvitationHandler) List(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(invitationDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > invitationMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	status := c.Query("status")
+	switch status {
+	case "", models.InvitationPending, models.InvitationAccepted, models.InvitationRevoked, models.InvitationExpired:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, accepted, revoked or expired"})
+		return
+	}
+
+	invitations, err := services.GetInvitations(status, limit, (page-1)*limit)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// Resend emails an invitation again with a fresh expiry.
+func (h *InvitationHandler) Resend(c *gin.Context) {
+	var req struct {
+		ExpiresInHours int `json:"expires_in_hours"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	invitation, err := services.ResendInvitation(c.Param("id"), commentActor(c), h.expiresIn(req.ExpiresInHours))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, invitation)
+}
+
+// Revoke stops an invitation from being accepted.
+func (h *InvitationHandler) Revoke(c *gin.Context) {
+	if err := services.RevokeInvitation(c.Param("id"), commentActor(c)); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// Lookup shows the invitee what an invitation link is for before they
+// accept it.
+func (h *InvitationHandler) Lookup(c *gin.Context) {
+	invitation, err := services.LookupInvitation(c.Query("token"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"email":           invitation.Email,
+		"role":            invitation.Role,
+		"message":         invitation.Message,
+		"invited_by_name": invitation.InvitedByName,
+		"expiresAt":       invitation.ExpiresAt,
+	})
+}
+
+// Accept creates the invitee's account from an invitation link.
+func (h *InvitationHandler) Accept(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		UserName string `json:"user_name" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := services.AcceptInvitation(req.Token, req.UserName, req.Password)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, user.Profile())
+}
+
+func (h *InvitationHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrInvitationRole),
+		errors.Is(err, services.ErrInvitationExpiry),
+		errors.Is(err, services.ErrInvitationMessage),
+		errors.Is(err, services.ErrUserNameRequired),
+		errors.Is(err, services.ErrWeakPassword),
+		errors.Is(err, services.ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInviterRole):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailInUse),
+		errors.Is(err, services.ErrUserNameInUse),
+		errors.Is(err, services.ErrAlreadyInvited),
+		errors.Is(err, services.ErrInvitationNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"goserver/internal/mailer"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mailEventMaxBody caps a webhook request. Providers batch events, so this
+// is generous.
+const mailEventMaxBody = 5 << 20
+
+type MailEventHandler struct {
+	sources map[string]mailer.EventSource
+}
+
+func NewMailEventHandler(sources map[string]mailer.EventSource) *MailEventHandler {
+	return &MailEventHandler{sources: sources}
+}
+
+// Receive takes delivery events from a mail provider's webhook. Providers
+// without a signing key configured are not enabled.
+func (h *MailEventHandler) Receive(c *gin.Context) {
+	source, ok := h.sources[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown mail provider"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, mailEventMaxBody))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+		return
+	}
+
+	events, err := source.Events(c.Request.Header, body)
+	switch {
+	case errors.Is(err, mailer.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A failure here makes the provider send the events again later.
+	if err := services.RecordDeliveryEvents(events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"received": len(events)})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MeHandler struct{}
+
+func NewMeHandler() *MeHandler {
+	return &MeHandler{}
+}
+
+// Get returns the caller's own profile.
+func (h *MeHandler) Get(c *gin.Context) {
+	profile, err := services.GetProfile(currentUserID(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// Update changes the fields of the caller's profile that are in the body.
+func (h *MeHandler) Update(c *gin.Context) {
+	var req struct {
+		DisplayName       *string               `json:"display_name"`
+		Bio               *string               `json:"bio"`
+		AvatarURL         *string               `json:"avatar_url"`
+		Links             *[]models.ProfileLink `json:"links"`
+		Locale            *string               `json:"locale"`
+		NotificationPrefs map[string][]string   `json:"notification_prefs"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := services.UpdateProfile(currentUserID(c), services.ProfileUpdate{
+		DisplayName:       req.DisplayName,
+		Bio:               req.Bio,
+		AvatarURL:         req.AvatarURL,
+		Links:             req.Links,
+		Locale:            req.Locale,
+		NotificationPrefs: req.NotificationPrefs,
+	})
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// ChangePassword sets a new password once the current one is confirmed.
+func (h *MeHandler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.ChangePassword(currentUserID(c), req.CurrentPassword, req.NewPassword); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+func (h *MeHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDisplayNameTooLong),
+		errors.Is(err, services.ErrBioTooLong),
+		errors.Is(err, services.ErrInvalidAvatarURL),
+		errors.Is(err, services.ErrInvalidLinks),
+		errors.Is(err, services.ErrInvalidLocale),
+		errors.Is(err, services.ErrUnknownNotificationPref),
+		errors.Is(err, services.ErrWeakPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWrongPassword):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
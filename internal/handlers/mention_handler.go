@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	autocompleteDefaultLimit = 10
+	autocompleteMaxLimit     = 25
+)
+
+type MentionHandler struct{}
+
+func NewMentionHandler() *MentionHandler {
+	return &MentionHandler{}
+}
+
+// Autocomplete suggests user names starting with ?q= for @mentions.
+func (h *MentionHandler) Autocomplete(c *gin.Context) {
+	prefix := strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "@")
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(autocompleteDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > autocompleteMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 25"})
+		return
+	}
+
+	users, err := services.AutocompleteUsers(prefix, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
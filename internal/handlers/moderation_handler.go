@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	moderationDefaultLimit = 50
+	moderationMaxLimit     = 200
+)
+
+type ModerationHandler struct{}
+
+func NewModerationHandler() *ModerationHandler {
+	return &ModerationHandler{}
+}
+
+// Queue lists comments awaiting review (or in another state with ?status=).
+func (h *ModerationHandler) Queue(c *gin.Context) {
+	status := c.DefaultQuery("status", models.CommentStatusPending)
+	switch status {
+	case models.CommentStatusPending, models.CommentStatusApproved, models.CommentStatusRejected, models.CommentStatusSpam:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status"})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(moderationDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > moderationMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+
+	comments, err := services.GetModerationQueue(status, commentActor(c), limit, (page-1)*limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// Moderate applies approve, reject or spam to a single comment.
+func (h *ModerationHandler) Moderate(c *gin.Context) {
+	h.apply(c, []string{c.Param("id")}, c.Param("action"))
+}
+
+// Bulk applies one action to many comments at once.
+func (h *ModerationHandler) Bulk(c *gin.Context) {
+	var req struct {
+		IDs    []string `json:"ids" binding:"required,min=1,max=500"`
+		Action string   `json:"action" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.apply(c, req.IDs, req.Action)
+}
+
+func (h *ModerationHandler) apply(c *gin.Context, ids []string, action string) {
+	result, err := services.ModerateComments(ids, action, commentActor(c))
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownModerationAction) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(ids) == 1 && len(result.Updated) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
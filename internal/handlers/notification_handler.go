@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	notificationDefaultLimit = 20
+	notificationMaxLimit     = 100
+)
+
+type NotificationHandler struct{}
+
+func NewNotificationHandler() *NotificationHandler {
+	return &NotificationHandler{}
+}
+
+// List returns a page of the caller's notifications, newest first, or only
+// unread ones with ?unread=true. When there are more the X-Next-Cursor
+// header holds the ?cursor= for the next page.
+func (h *NotificationHandler) List(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(notificationDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > notificationMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	notifications, next, err := services.GetNotifications(currentUserID(c), c.Query("unread") == "true", limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+	c.JSON(http.StatusOK, notifications)
+}
+
+// UnreadCount returns how many notifications the caller has not read.
+func (h *NotificationHandler) UnreadCount(c *gin.Context) {
+	count, err := services.CountUnreadNotifications(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": count})
+}
+
+// MarkRead marks one of the caller's notifications as read.
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	err := services.MarkNotificationRead(currentUserID(c), c.Param("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// MarkAllRead marks all of the caller's notifications as read.
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	count, err := services.MarkAllNotificationsRead(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": count})
+}
+
+// GetPreferences returns the caller's notification preferences.
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	prefs, err := services.GetNotificationPrefs(currentUserID(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdatePreferences sets which channels the caller gets each event on, e.g.
+// {"mention": ["in_app", "email"]}. An empty list turns the event off.
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	var prefs map[string][]string
+	if err := c.ShouldBindJSON(&prefs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.SetNotificationPrefs(currentUserID(c), prefs); err != nil {
+		if errors.Is(err, services.ErrUnknownNotificationPref) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := services.GetNotificationPrefs(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdateWebhook sets or, with an empty webhook_url, removes the URL the
+// caller's webhook notifications are sent to. Setting a URL returns the
+// secret that signs them.
+func (h *NotificationHandler) UpdateWebhook(c *gin.Context) {
+	var req struct {
+		WebhookURL string `json:"webhook_url"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := services.SetWebhookURL(currentUserID(c), req.WebhookURL)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWebhookURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if secret == "" {
+		c.JSON(http.StatusOK, gin.H{"webhook_url": req.WebhookURL})
+		return
+	}
+	// The secret is only ever shown here
+	c.JSON(http.StatusOK, gin.H{"webhook_url": req.WebhookURL, "webhook_secret": secret})
+}
+
+// UpdateLocale sets the language the caller's email is sent in, e.g.
+// {"locale": "es"}. An empty locale goes back to the site default.
+func (h *NotificationHandler) UpdateLocale(c *gin.Context) {
+	var req struct {
+		Locale string `json:"locale"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	locale, err := services.SetUserLocale(currentUserID(c), req.Locale)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLocale) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"locale": locale})
+}
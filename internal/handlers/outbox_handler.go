@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	outboxDefaultLimit = 50
+	outboxMaxLimit     = 200
+)
+
+type OutboxHandler struct{}
+
+func NewOutboxHandler() *OutboxHandler {
+	return &OutboxHandler{}
+}
+
+// List shows dead-lettered email (or email in another state with ?status=).
+func (h *OutboxHandler) List(c *gin.Context) {
+	status := c.DefaultQuery("status", models.EmailStatusDead)
+	switch status {
+	case models.EmailStatusQueued, models.EmailStatusSending, models.EmailStatusSent, models.EmailStatusDead, models.EmailStatusSuppressed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status"})
+		return
+	}
+
+	limit, skip, ok := h.page(c)
+	if !ok {
+		return
+	}
+
+	emails, err := services.GetOutboxEmails(status, limit, skip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, emails)
+}
+
+// page reads the limit and page query parameters.
+func (h *OutboxHandler) page(c *gin.Context) (limit, skip int64, ok bool) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(outboxDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > outboxMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return 0, 0, false
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return 0, 0, false
+	}
+	return limit, (page - 1) * limit, true
+}
+
+func (h *OutboxHandler) Get(c *gin.Context) {
+	email, err := services.GetOutboxEmail(c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, email)
+}
+
+// Retry queues one email for delivery again.
+func (h *OutboxHandler) Retry(c *gin.Context) {
+	err := services.RetryOutboxEmail(c.Param("id"))
+	switch {
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+	case errors.Is(err, services.ErrEmailNotRetryable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Email queued for delivery", "id": c.Param("id")})
+	}
+}
+
+// RetryDead queues every dead-lettered email for delivery again.
+func (h *OutboxHandler) RetryDead(c *gin.Context) {
+	count, err := services.RetryDeadEmails()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Dead email queued for delivery", "count": count})
+}
+
+// Suppressions lists the addresses email is no longer sent to.
+func (h *OutboxHandler) Suppressions(c *gin.Context) {
+	limit, skip, ok := h.page(c)
+	if !ok {
+		return
+	}
+
+	suppressions, err := services.GetSuppressions(limit, skip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, suppressions)
+}
+
+// Unsuppress lets email be sent to an address again.
+func (h *OutboxHandler) Unsuppress(c *gin.Context) {
+	err := services.RemoveSuppression(c.Param("email"))
+	switch {
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address is not suppressed"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Address removed from the suppression list"})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"goserver/internal/config"
+	"goserver/internal/ratelimit"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReactionHandler struct {
+	cfg     *config.Config
+	limiter *ratelimit.Limiter
+}
+
+func NewReactionHandler(cfg *config.Config) *ReactionHandler {
+	return &ReactionHandler{
+		cfg:     cfg,
+		limiter: ratelimit.New(cfg.AnonymousReactionLimit, cfg.AnonymousReactionWindow),
+	}
+}
+
+// List returns the reaction counts of a target and who left each reaction.
+func (h *ReactionHandler) List(c *gin.Context) {
+	targetType, targetID := c.Param("targetType"), c.Param("targetId")
+
+	counts, err := services.GetReactionCounts(targetType, targetID)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	reactors, err := services.GetReactors(targetType, targetID, c.Query("type"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"counts": counts, "reactors": reactors})
+}
+
+func (h *ReactionHandler) Put(c *gin.Context) {
+	h.mutate(c, services.SetReaction)
+}
+
+func (h *ReactionHandler) Delete(c *gin.Context) {
+	h.mutate(c, services.RemoveReaction)
+}
+
+func (h *ReactionHandler) mutate(c *gin.Context, apply func(targetType, targetID, reactionType string, actor services.ReactionActor) (bool, error)) {
+	targetType, targetID, reactionType := c.Param("targetType"), c.Param("targetId"), c.Param("type")
+
+	if !h.allowedType(reactionType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown reaction type", "allowed": h.cfg.ReactionTypes})
+		return
+	}
+
+	actor, ok := h.actor(c)
+	if !ok {
+		return
+	}
+
+	changed, err := apply(targetType, targetID, reactionType, actor)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	counts, err := services.GetReactionCounts(targetType, targetID)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changed": changed, "counts": counts})
+}
+
+// actor identifies the caller, falling back to a throttled anonymous
+// identity when anonymous reactions are enabled.
+func (h *ReactionHandler) actor(c *gin.Context) (services.ReactionActor, bool) {
+	if userID := currentUserID(c); userID != "" {
+		return services.ReactionActor{UserID: userID, UserName: currentUserName(c)}, true
+	}
+
+	if !h.cfg.AnonymousReactions {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in to react"})
+		return services.ReactionActor{}, false
+	}
+	if !h.limiter.Allow(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many reactions, please slow down"})
+		return services.ReactionActor{}, false
+	}
+
+	sum := sha256.Sum256([]byte(c.ClientIP() + "|" + c.Request.UserAgent()))
+	return services.ReactionActor{UserID: "anon:" + hex.EncodeToString(sum[:12]), Anonymous: true}, true
+}
+
+func (h *ReactionHandler) allowedType(reactionType string) bool {
+	for _, t := range h.cfg.ReactionTypes {
+		if t == reactionType {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *ReactionHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownReactionTarget), errors.Is(err, primitive.ErrInvalidHex):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
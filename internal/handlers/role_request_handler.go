@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	roleRequestDefaultLimit = 50
+	roleRequestMaxLimit     = 200
+)
+
+type RoleRequestHandler struct{}
+
+func NewRoleRequestHandler() *RoleRequestHandler {
+	return &RoleRequestHandler{}
+}
+
+type roleDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Create asks the admins for a higher role for the caller.
+func (h *RoleRequestHandler) Create(c *gin.Context) {
+	var req struct {
+		Role          string `json:"role" binding:"required"`
+		Justification string `json:"justification" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := services.RequestRole(currentUserID(c), req.Role, req.Justification)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, request)
+}
+
+// Mine lists the caller's own role requests, newest first.
+func (h *RoleRequestHandler) Mine(c *gin.Context) {
+	requests, err := services.GetUserRoleRequests(currentUserID(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// List shows the admin queue. It defaults to pending requests, oldest
+// first; status=all shows every request.
+func (h *RoleRequestHandler) List(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(roleRequestDefaultLimit)), 10, 64)
+	if err != nil || limit < 1 || limit > roleRequestMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	status := c.DefaultQuery("status", models.RoleRequestPending)
+	switch status {
+	case models.RoleRequestPending, models.RoleRequestApproved, models.RoleRequestDenied:
+	case "all":
+		status = ""
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, approved, denied or all"})
+		return
+	}
+
+	requests, err := services.GetRoleRequests(status, limit, (page-1)*limit)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// Approve gives the user the role they asked for, which their session
+// carries from the next time they sign in. A reason is optional.
+func (h *RoleRequestHandler) Approve(c *gin.Context) {
+	h.decide(c, true)
+}
+
+// Deny turns a request down with a reason, which is sent to the user.
+func (h *RoleRequestHandler) Deny(c *gin.Context) {
+	h.decide(c, false)
+}
+
+func (h *RoleRequestHandler) decide(c *gin.Context, approve bool) {
+	var req roleDecisionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	request, err := services.DecideRoleRequest(c.Param("id"), commentActor(c), approve, req.Reason)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *RoleRequestHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRoleNotRequestable),
+		errors.Is(err, services.ErrJustificationLength),
+		errors.Is(err, services.ErrRoleRequestReason):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleRequestPending),
+		errors.Is(err, services.ErrRoleRequestDecided),
+		errors.Is(err, services.ErrRoleRequestUserMoved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleRequestTooSoon):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role request not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sitemapPageSize is the protocol limit on URLs per sitemap file.
+const sitemapPageSize = 50000
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type SEOHandler struct {
+	cfg *config.Config
+}
+
+func NewSEOHandler(cfg *config.Config) *SEOHandler {
+	return &SEOHandler{cfg: cfg}
+}
+
+// Sitemap serves /sitemap.xml, which becomes a sitemap index pointing at
+// /sitemaps/N.xml once the site has more URLs than fit in a single file.
+func (h *SEOHandler) Sitemap(c *gin.Context) {
+	sitemap, err := services.GetSitemap(h.cfg.FrontendURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if notModified(c, sitemapETag(sitemap, 0), sitemap.GeneratedAt) {
+		return
+	}
+
+	if len(sitemap.URLs) <= sitemapPageSize {
+		h.writeURLSet(c, sitemap.URLs)
+		return
+	}
+
+	index := sitemapIndex{}
+	base := requestBaseURL(c)
+	for page := 1; (page-1)*sitemapPageSize < len(sitemap.URLs); page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     fmt.Sprintf("%s/sitemaps/%d.xml", base, page),
+			LastMod: formatLastMod(latestLastMod(pageOf(sitemap.URLs, page))),
+		})
+	}
+	writeXML(c, index)
+}
+
+// SitemapPage serves one page of a sitemap index.
+func (h *SEOHandler) SitemapPage(c *gin.Context) {
+	page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sitemap not found"})
+		return
+	}
+
+	sitemap, err := services.GetSitemap(h.cfg.FrontendURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	urls := pageOf(sitemap.URLs, page)
+	if len(urls) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sitemap not found"})
+		return
+	}
+	if notModified(c, sitemapETag(sitemap, page), sitemap.GeneratedAt) {
+		return
+	}
+	h.writeURLSet(c, urls)
+}
+
+func (h *SEOHandler) Robots(c *gin.Context) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if h.cfg.RobotsDisallowAll {
+		b.WriteString("Disallow: /\n")
+	} else {
+		for _, path := range h.cfg.RobotsDisallow {
+			fmt.Fprintf(&b, "Disallow: %s\n", path)
+		}
+		fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", requestBaseURL(c))
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}
+
+func (h *SEOHandler) writeURLSet(c *gin.Context, urls []services.SitemapURL) {
+	set := urlSet{URLs: make([]sitemapURL, 0, len(urls))}
+	for _, u := range urls {
+		set.URLs = append(set.URLs, sitemapURL{Loc: u.Loc, LastMod: formatLastMod(u.LastMod)})
+	}
+	writeXML(c, set)
+}
+
+func writeXML(c *gin.Context, v interface{}) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+func pageOf(urls []services.SitemapURL, page int) []services.SitemapURL {
+	start := (page - 1) * sitemapPageSize
+	if start >= len(urls) {
+		return nil
+	}
+	end := start + sitemapPageSize
+	if end > len(urls) {
+		end = len(urls)
+	}
+	return urls[start:end]
+}
+
+func latestLastMod(urls []services.SitemapURL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+	return latest
+}
+
+func formatLastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func sitemapETag(sitemap *services.Sitemap, page int) string {
+	return fmt.Sprintf(`"sitemap-%d-%d"`, page, sitemap.GeneratedAt.UnixNano())
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"goserver/internal/config"
+	"goserver/internal/realtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type SocketHandler struct {
+	upgrader websocket.Upgrader
+}
+
+func NewSocketHandler(cfg *config.Config) *SocketHandler {
+	frontend := strings.TrimSuffix(cfg.FrontendURL, "/")
+	return &SocketHandler{
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Browsers send cookies and tickets along from any page, so
+			// only the frontend may open sockets from a browser.
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				return origin == "" || origin == frontend
+			},
+		},
+	}
+}
+
+// Connect upgrades an authenticated request to a WebSocket and serves the
+// channel protocol on it.
+func (h *SocketHandler) Connect(c *gin.Context) {
+	if realtime.DefaultHub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Live updates are not available"})
+		return
+	}
+
+	userID := currentUserID(c)
+	release, err := realtime.DefaultHub.Admit(userID)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response.
+		return
+	}
+	realtime.DefaultHub.Serve(conn, socketClient(c))
+}
+
+// Ticket trades the caller's bearer token for a single-use ticket that
+// opens a socket from a browser, which can't send the token as a header.
+func (h *SocketHandler) Ticket(c *gin.Context) {
+	ticket, err := realtime.IssueTicket(socketClient(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(realtime.TicketTTL.Seconds())})
+}
+
+// socketClient identifies the authenticated caller to the socket hub.
+func socketClient(c *gin.Context) realtime.SocketClient {
+	return realtime.SocketClient{
+		UserID:    currentUserID(c),
+		UserName:  currentUserName(c),
+		Role:      currentRole(c),
+		ExpiresAt: c.GetTime("tokenExpiresAt"),
+	}
+}
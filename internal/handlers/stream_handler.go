@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/realtime"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamRetry tells EventSource clients how long to wait, in milliseconds,
+// before reconnecting.
+const streamRetry = 3000
+
+type StreamHandler struct {
+	cfg *config.Config
+}
+
+func NewStreamHandler(cfg *config.Config) *StreamHandler {
+	return &StreamHandler{cfg: cfg}
+}
+
+// Blog streams comment events for one post as Server-Sent Events.
+func (h *StreamHandler) Blog(c *gin.Context) {
+	blog, err := services.GetBlogByID(c.Param("id"))
+	if err != nil || blog == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
+		return
+	}
+	h.stream(c, realtime.BlogTopic(blog.ID.Hex()))
+}
+
+// Posts streams an event for every newly published post.
+func (h *StreamHandler) Posts(c *gin.Context) {
+	h.stream(c, realtime.TopicPosts)
+}
+
+// stream relays the events of topic until the client goes away. Clients
+// resume with the Last-Event-ID header, or ?lastEventId= where they can't
+// set headers. A client that can't keep up is disconnected and expected to
+// reconnect and resume.
+func (h *StreamHandler) stream(c *gin.Context, topic string) {
+	if realtime.DefaultBroker == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Live updates are not available"})
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+	sub, err := realtime.DefaultBroker.Subscribe(topic, lastEventID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(h.cfg.StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			_, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+			if err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/config"
+	"goserver/internal/ratelimit"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SubscriptionHandler struct {
+	limiter *ratelimit.Limiter
+}
+
+func NewSubscriptionHandler(cfg *config.Config) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		limiter: ratelimit.New(cfg.SubscribeLimit, cfg.SubscribeWindow),
+	}
+}
+
+type subscriptionRequest struct {
+	Email    string `json:"email"`
+	Scope    string `json:"scope"`
+	Target   string `json:"target"`
+	Delivery string `json:"delivery"`
+}
+
+type subscriptionTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// Create subscribes the caller to new posts. Signed-in users are subscribed
+// straight away. Anyone else gets the same answer whether or not the
+// address was already subscribed, so that the endpoint can't be used to
+// find out who reads the blog.
+func (h *SubscriptionHandler) Create(c *gin.Context) {
+	var req subscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := currentUserID(c)
+	if userID == "" {
+		if req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidEmail.Error()})
+			return
+		}
+		if !h.limiter.Allow(c.ClientIP()) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many subscriptions, please try again later"})
+			return
+		}
+	}
+
+	sub, err := services.Subscribe(services.SubscriptionRequest{
+		Email:    req.Email,
+		Scope:    req.Scope,
+		Target:   req.Target,
+		Delivery: req.Delivery,
+	}, userID)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+
+	if userID == "" {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Check your inbox for a link to confirm your subscription"})
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+// List returns the caller's subscriptions.
+func (h *SubscriptionHandler) List(c *gin.Context) {
+	subs, err := services.GetSubscriptions(currentUserID(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, subs)
+}
+
+// Update changes how one of the caller's subscriptions is delivered.
+func (h *SubscriptionHandler) Update(c *gin.Context) {
+	var req struct {
+		Delivery string `json:"delivery" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub, err := services.UpdateSubscriptionDelivery(currentUserID(c), c.Param("id"), req.Delivery)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (h *SubscriptionHandler) Delete(c *gin.Context) {
+	if err := services.DeleteSubscription(currentUserID(c), c.Param("id")); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed"})
+}
+
+// Confirm activates a subscription from the link in a confirmation email.
+func (h *SubscriptionHandler) Confirm(c *gin.Context) {
+	sub, err := services.ConfirmSubscription(h.token(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// Unsubscribe deletes a subscription from the link in one of its emails.
+// Mail providers POST here directly for RFC 8058 one-click unsubscribes,
+// with the token in the query string and a form body.
+func (h *SubscriptionHandler) Unsubscribe(c *gin.Context) {
+	if err := services.Unsubscribe(h.token(c)); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed"})
+}
+
+// token reads a signed token from the query string or a JSON body.
+func (h *SubscriptionHandler) token(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	var req subscriptionTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return ""
+	}
+	return req.Token
+}
+
+func (h *SubscriptionHandler) error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownSubscriptionScope),
+		errors.Is(err, services.ErrUnknownDelivery),
+		errors.Is(err, services.ErrSubscriptionTarget),
+		errors.Is(err, services.ErrInvalidEmail),
+		errors.Is(err, services.ErrInvalidToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == mongo.ErrNoDocuments:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
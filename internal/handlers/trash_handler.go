@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type TrashHandler struct{}
+
+func NewTrashHandler() *TrashHandler {
+	return &TrashHandler{}
+}
+
+func (h *TrashHandler) ListBlogs(c *gin.Context) {
+	blogs, err := services.GetTrashedBlogs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, blogs)
+}
+
+func (h *TrashHandler) ListComments(c *gin.Context) {
+	comments, err := services.GetTrashedComments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+func (h *TrashHandler) ListUsers(c *gin.Context) {
+	users, err := services.GetTrashedUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.Profiles(users))
+}
+
+func (h *TrashHandler) RestoreBlog(c *gin.Context) {
+	restore(c, "Blog", services.RestoreBlog)
+}
+
+func (h *TrashHandler) RestoreComment(c *gin.Context) {
+	restore(c, "Comment", services.RestoreComment)
+}
+
+func (h *TrashHandler) RestoreUser(c *gin.Context) {
+	restore(c, "User", services.RestoreUser)
+}
+
+func restore(c *gin.Context, kind string, restoreFn func(id string) error) {
+	id := c.Param("id")
+	if err := restoreFn(id); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found in trash"})
+			return
+		}
+		if errors.Is(err, services.ErrContentHandedOver) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": kind + " restored successfully",
+		"id":      id,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"goserver/internal/models"
 	"goserver/internal/services"
 	"net/http"
@@ -20,7 +21,7 @@ func (h *UserHandler) GetAll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, models.Profiles(users))
 }
 
 func (h *UserHandler) GetByID(c *gin.Context) {
@@ -30,20 +31,35 @@ func (h *UserHandler) GetByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, user.Public())
 }
 
+// Create signs up a new user. Only the name, address and password are
+// taken; the rest of the profile is edited once the account exists.
 func (h *UserHandler) Create(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var req struct {
+		UserName string `json:"user_name" binding:"required"`
+		Email    string `json:"user_email" binding:"required"`
+		Password string `json:"user_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := services.CreateUser(&user); err != nil {
+	user, err := services.CreateUser(req.UserName, req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNameRequired) || errors.Is(err, services.ErrInvalidEmail) || errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrEmailInUse) || errors.Is(err, services.ErrUserNameInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, user)
+	c.JSON(http.StatusCreated, user.Profile())
 }
 
 func (h *UserHandler) Update(c *gin.Context) {
@@ -54,11 +70,15 @@ func (h *UserHandler) Update(c *gin.Context) {
 		return
 	}
 
-	if err := services.UpdateUser(id, &user); err != nil {
+	if err := services.UpdateUser(id, currentUserID(c), &user); err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
+		if errors.Is(err, services.ErrEmailInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -69,14 +89,24 @@ func (h *UserHandler) Update(c *gin.Context) {
 func (h *UserHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 
-	if err := services.DeleteUser(id); err != nil {
+	opts := services.DeleteUserOptions{
+		Content:    c.Query("content"),
+		ReassignTo: c.Query("reassign_to"),
+	}
+
+	affected, err := services.DeleteUser(id, currentUserID(c), opts)
+	if err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
+		if errors.Is(err, services.ErrUnknownContentMode) || errors.Is(err, services.ErrReassignTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "affected": affected})
 }
@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of delivery event reported by a provider.
+const (
+	EventDelivered = "delivered"
+	EventDeferred  = "deferred"
+	EventBounce    = "bounce"
+	EventDropped   = "dropped"
+	EventComplaint = "complaint"
+)
+
+// Providers that can report delivery events.
+const (
+	ProviderSendGrid = "sendgrid"
+	ProviderWebhook  = "webhook"
+)
+
+// SignatureTolerance is how far a signed webhook's timestamp may be from
+// now. Older requests are refused so that a captured one can't be replayed.
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is missing or invalid")
+	ErrInvalidEvents    = errors.New("webhook body is not a list of delivery events")
+)
+
+// Event is something that happened to a sent email, in a form that doesn't
+// depend on the provider that reported it. Permanent marks failures that
+// will happen again, like a hard bounce, as opposed to a full mailbox.
+type Event struct {
+	Type      string    `json:"type"`
+	Email     string    `json:"email"`
+	Reason    string    `json:"reason,omitempty"`
+	Permanent bool      `json:"permanent,omitempty"`
+	At        time.Time `json:"timestamp"`
+	Provider  string    `json:"provider,omitempty"`
+}
+
+// Suppresses reports whether no more email should be sent to the address:
+// the recipient complained, or delivery failed for good.
+func (e Event) Suppresses() bool {
+	switch e.Type {
+	case EventComplaint:
+		return true
+	case EventBounce, EventDropped:
+		return e.Permanent
+	}
+	return false
+}
+
+// EventSource turns a provider's webhook request into events, after
+// checking that the provider really sent it.
+type EventSource interface {
+	Events(header http.Header, body []byte) ([]Event, error)
+}
+
+// EventOptions configures the event sources NewEventSources builds. A
+// provider is only enabled when its signing key is set, so that nobody can
+// post unsigned events.
+type EventOptions struct {
+	// SendGridPublicKey verifies SendGrid's signed event webhook.
+	SendGridPublicKey string
+	// WebhookSecret verifies events posted in this package's own format,
+	// for providers and relays without a parser of their own.
+	WebhookSecret string
+}
+
+// NewEventSources returns the enabled event sources by provider name.
+func NewEventSources(opts EventOptions) (map[string]EventSource, error) {
+	sources := map[string]EventSource{}
+	if opts.SendGridPublicKey != "" {
+		source, err := NewSendGridEvents(opts.SendGridPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		sources[ProviderSendGrid] = source
+	}
+	if opts.WebhookSecret != "" {
+		sources[ProviderWebhook] = &WebhookEvents{Secret: opts.WebhookSecret}
+	}
+	return sources, nil
+}
+
+// recentTimestamp reports whether value, in Unix seconds, is within
+// SignatureTolerance of now.
+func recentTimestamp(value string) bool {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	return age < SignatureTolerance && age > -SignatureTolerance
+}
+
+// WebhookEvents reads a JSON list of Events signed the same way our own
+// notification webhooks are: HMAC-SHA256 of the X-Signature-Timestamp
+// header, a dot and the body, in the X-Signature-256 header.
+type WebhookEvents struct {
+	Secret string
+}
+
+func (w *WebhookEvents) Events(header http.Header, body []byte) ([]Event, error) {
+	signature, ok := strings.CutPrefix(header.Get("X-Signature-256"), "sha256=")
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	timestamp := header.Get("X-Signature-Timestamp")
+	if !recentTimestamp(timestamp) {
+		return nil, ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var events []Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, ErrInvalidEvents
+	}
+	for i := range events {
+		events[i].Email = strings.TrimSpace(events[i].Email)
+		events[i].Provider = ProviderWebhook
+	}
+	return events, nil
+}
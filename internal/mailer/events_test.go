@@ -0,0 +1,140 @@
+package mailer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	const secret = "webhook-secret"
+	body := []byte(`[{"type":"bounce","email":" a@example.com ","permanent":true}]`)
+	sign := func(timestamp string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-SignatureTolerance-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(SignatureTolerance+time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		wantErr   error
+	}{
+		{"valid", now, sign(now, body), body, nil},
+		{"stale timestamp", stale, sign(stale, body), body, ErrInvalidSignature},
+		{"future timestamp", future, sign(future, body), body, ErrInvalidSignature},
+		{"missing timestamp", "", sign("", body), body, ErrInvalidSignature},
+		{"timestamp not signed", now, sign(stale, body), body, ErrInvalidSignature},
+		{"body changed", now, sign(now, body), []byte(`[]`), ErrInvalidSignature},
+		{"missing signature", now, "", body, ErrInvalidSignature},
+		{"signature not hex", now, "sha256=zz", body, ErrInvalidSignature},
+		{"not a list", now, sign(now, []byte(`{}`)), []byte(`{}`), ErrInvalidEvents},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.timestamp != "" {
+				header.Set("X-Signature-Timestamp", tt.timestamp)
+			}
+			if tt.signature != "" {
+				header.Set("X-Signature-256", tt.signature)
+			}
+			events, err := (&WebhookEvents{Secret: secret}).Events(header, tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Events() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(events) != 1 || events[0].Email != "a@example.com" || events[0].Provider != ProviderWebhook || !events[0].Suppresses() {
+				t.Errorf("Events() = %+v, want one suppressing bounce for a@example.com", events)
+			}
+		})
+	}
+}
+
+func TestSendGridEvents(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewSendGridEvents(base64.StdEncoding.EncodeToString(der))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`[{"email":"a@example.com","timestamp":1700000000,"event":"bounce","type":"bounce"},{"email":"b@example.com","event":"open"}]`)
+	sign := func(key *ecdsa.PrivateKey, timestamp string, body []byte) string {
+		digest := sha256.Sum256(append([]byte(timestamp), body...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-SignatureTolerance-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		wantErr   error
+	}{
+		{"valid", now, sign(key, now, body), body, nil},
+		{"stale timestamp", stale, sign(key, stale, body), body, ErrInvalidSignature},
+		{"missing timestamp", "", sign(key, "", body), body, ErrInvalidSignature},
+		{"timestamp not signed", now, sign(key, stale, body), body, ErrInvalidSignature},
+		{"other key", now, sign(otherKey, now, body), body, ErrInvalidSignature},
+		{"body changed", now, sign(key, now, body), []byte(`[]`), ErrInvalidSignature},
+		{"missing signature", now, "", body, ErrInvalidSignature},
+		{"not a list", now, sign(key, now, []byte(`{}`)), []byte(`{}`), ErrInvalidEvents},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.timestamp != "" {
+				header.Set("X-Twilio-Email-Event-Webhook-Timestamp", tt.timestamp)
+			}
+			if tt.signature != "" {
+				header.Set("X-Twilio-Email-Event-Webhook-Signature", tt.signature)
+			}
+			events, err := source.Events(header, tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Events() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// Opens aren't delivery events, so only the bounce is kept
+			if len(events) != 1 || events[0].Email != "a@example.com" || events[0].Type != EventBounce || !events[0].Permanent {
+				t.Errorf("Events() = %+v, want one hard bounce for a@example.com", events)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package mailer delivers email through a pluggable backend: SendGrid, SMTP,
+// a maildir on disk for development, or memory for tests.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Backends accepted by New.
+const (
+	BackendSendGrid = "sendgrid"
+	BackendSMTP     = "smtp"
+	BackendFile     = "file"
+	BackendMemory   = "memory"
+)
+
+var ErrUnknownBackend = errors.New("mail backend must be one of sendgrid, smtp, file or memory")
+
+// Message is one email. From and To may include a display name, as in
+// "Ed <ed@example.com>". At least one of Text and HTML should be set.
+// Headers adds headers such as List-Unsubscribe.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Options configures the backend New builds. Only the fields of the chosen
+// backend are used.
+type Options struct {
+	Backend string
+
+	SendGridAPIKey string
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPRequireTLS refuses to send to servers that don't offer STARTTLS.
+	SMTPRequireTLS bool
+
+	// Dir is the maildir the file backend writes to.
+	Dir string
+}
+
+// New returns the Mailer selected by opts.Backend.
+func New(opts Options) (Mailer, error) {
+	switch strings.ToLower(opts.Backend) {
+	case BackendSendGrid, "":
+		return NewSendGridMailer(opts.SendGridAPIKey), nil
+	case BackendSMTP:
+		if opts.SMTPHost == "" {
+			return nil, errors.New("the smtp mail backend needs a host")
+		}
+		return &SMTPMailer{
+			Host:       opts.SMTPHost,
+			Port:       opts.SMTPPort,
+			Username:   opts.SMTPUsername,
+			Password:   opts.SMTPPassword,
+			RequireTLS: opts.SMTPRequireTLS,
+		}, nil
+	case BackendFile:
+		if opts.Dir == "" {
+			return nil, errors.New("the file mail backend needs a directory")
+		}
+		return &FileMailer{Dir: opts.Dir}, nil
+	case BackendMemory:
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("%w, not %q", ErrUnknownBackend, opts.Backend)
+	}
+}
+
+// withDefaultDeadline bounds ctx when the caller didn't.
+func withDefaultDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, 30*time.Second)
+}
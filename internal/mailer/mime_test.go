@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestEncodeHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		header  string
+		raw     string
+		decoded string
+	}{
+		{
+			name:    "ascii subject is left alone",
+			msg:     Message{Subject: "Hello there"},
+			header:  "Subject",
+			raw:     "Hello there",
+			decoded: "Hello there",
+		},
+		{
+			name:    "non-ascii subject is Q-encoded",
+			msg:     Message{Subject: "Café ☕"},
+			header:  "Subject",
+			raw:     "=?utf-8?q?Caf=C3=A9_=E2=98=95?=",
+			decoded: "Café ☕",
+		},
+		{
+			name:    "display name is quoted",
+			msg:     Message{From: "Ed and Linda <ed@example.com>"},
+			header:  "From",
+			raw:     `"Ed and Linda" <ed@example.com>`,
+			decoded: `"Ed and Linda" <ed@example.com>`,
+		},
+		{
+			name:    "extra header keys are canonicalized",
+			msg:     Message{Headers: map[string]string{"list-unsubscribe": "<https://example.com/u>"}},
+			header:  "List-Unsubscribe",
+			raw:     "<https://example.com/u>",
+			decoded: "<https://example.com/u>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.msg
+			if msg.From == "" {
+				msg.From = "from@example.com"
+			}
+			msg.To = "to@example.com"
+			msg.Text = "body"
+
+			parsed := encodeAndParse(t, msg)
+			raw := parsed.Header.Get(tt.header)
+			if raw != tt.raw {
+				t.Errorf("%s = %q, want %q", tt.header, raw, tt.raw)
+			}
+			decoded, err := new(mime.WordDecoder).DecodeHeader(raw)
+			if err != nil {
+				t.Fatalf("decoding %s: %v", tt.header, err)
+			}
+			if decoded != tt.decoded {
+				t.Errorf("decoded %s = %q, want %q", tt.header, decoded, tt.decoded)
+			}
+		})
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"bad from", Message{From: "not an address", To: "to@example.com"}},
+		{"bad to", Message{From: "from@example.com", To: "to@"}},
+		{"newline in header value", Message{From: "from@example.com", To: "to@example.com",
+			Headers: map[string]string{"X-Test": "a\r\nBcc: evil@example.com"}}},
+		{"colon in header key", Message{From: "from@example.com", To: "to@example.com",
+			Headers: map[string]string{"X-Test: a": "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := encode(tt.msg); err == nil {
+				t.Error("encode() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	long := strings.Repeat("ünïcode ", 20)
+	tests := []struct {
+		name      string
+		text      string
+		html      string
+		wantType  string
+		wantParts map[string]string
+	}{
+		{"text only", long, "", "text/plain", map[string]string{"text/plain": long}},
+		{"html only", "", "<p>Hi</p>", "text/html", map[string]string{"text/html": "<p>Hi</p>"}},
+		{"both", long, "<p>Hi</p>", "multipart/alternative", map[string]string{"text/plain": long, "text/html": "<p>Hi</p>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := encodeAndParse(t, Message{From: "from@example.com", To: "to@example.com", Text: tt.text, HTML: tt.html})
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mediaType != tt.wantType {
+				t.Fatalf("Content-Type = %q, want %q", mediaType, tt.wantType)
+			}
+
+			got := map[string]string{}
+			if mediaType != "multipart/alternative" {
+				got[mediaType] = readQuotedPrintable(t, parsed.Body)
+			} else {
+				parts := multipart.NewReader(parsed.Body, params["boundary"])
+				for {
+					part, err := parts.NextRawPart()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+					got[partType] = readQuotedPrintable(t, part)
+				}
+			}
+			for contentType, want := range tt.wantParts {
+				if got[contentType] != want {
+					t.Errorf("%s body = %q, want %q", contentType, got[contentType], want)
+				}
+			}
+			if len(got) != len(tt.wantParts) {
+				t.Errorf("got %d parts, want %d", len(got), len(tt.wantParts))
+			}
+		})
+	}
+}
+
+func encodeAndParse(t *testing.T, msg Message) *mail.Message {
+	t.Helper()
+	data, err := encode(msg)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 998 {
+			t.Fatalf("line longer than 998 characters: %q", line)
+		}
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("parsing encoded message: %v", err)
+	}
+	return parsed
+}
+
+func readQuotedPrintable(t *testing.T, r io.Reader) string {
+	t.Helper()
+	body, err := io.ReadAll(quotedprintable.NewReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// SendGridMailer sends email through the SendGrid API.
+type SendGridMailer struct {
+	client *sendgrid.Client
+}
+
+// NewSendGridMailer returns a SendGridMailer. The client is created once and
+// shared by every send.
+func NewSendGridMailer(apiKey string) *SendGridMailer {
+	return &SendGridMailer{client: sendgrid.NewSendClient(apiKey)}
+}
+
+func (m *SendGridMailer) Send(ctx context.Context, msg Message) error {
+	from, err := address(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return err
+	}
+
+	// Use HTML if provided, otherwise fall back to text
+	content := msg.HTML
+	if content == "" {
+		content = msg.Text
+	}
+	message := mail.NewSingleEmail(
+		mail.NewEmail(from.Name, from.Address), msg.Subject,
+		mail.NewEmail(to.Name, to.Address), msg.Text, content)
+	for key, value := range msg.Headers {
+		message.SetHeader(key, value)
+	}
+
+	ctx, cancel := withDefaultDeadline(ctx)
+	defer cancel()
+	response, err := m.client.SendWithContext(ctx, message)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid responded %d: %s", response.StatusCode, response.Body)
+	}
+	return nil
+}
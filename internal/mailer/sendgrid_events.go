@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SendGridEvents verifies and reads SendGrid's signed event webhook.
+type SendGridEvents struct {
+	key *ecdsa.PublicKey
+}
+
+// NewSendGridEvents returns a SendGridEvents that verifies requests with the
+// base64 verification key shown in SendGrid's mail settings.
+func NewSendGridEvents(publicKey string) (*SendGridEvents, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil {
+		return nil, errors.New("sendgrid webhook key is not valid base64")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sendgrid webhook key is not an ECDSA key")
+	}
+	return &SendGridEvents{key: key}, nil
+}
+
+type sendGridEvent struct {
+	Email     string `json:"email"`
+	Timestamp int64  `json:"timestamp"`
+	Event     string `json:"event"`
+	// Type tells a hard bounce from a block for bounce events.
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// sendGridPermanentDrops are the reasons SendGrid gives for dropping email
+// to an address that will never accept it.
+var sendGridPermanentDrops = map[string]bool{
+	"Bounced Address":        true,
+	"Invalid":                true,
+	"Spam Reporting Address": true,
+}
+
+func (s *SendGridEvents) Events(header http.Header, body []byte) ([]Event, error) {
+	signature, err := base64.StdEncoding.DecodeString(header.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidSignature
+	}
+	timestamp := header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+	if !recentTimestamp(timestamp) || !ecdsa.VerifyASN1(s.key, digest[:], signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	var raw []sendGridEvent
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, ErrInvalidEvents
+	}
+	events := make([]Event, 0, len(raw))
+	for _, e := range raw {
+		event := Event{
+			Email:    strings.TrimSpace(e.Email),
+			Reason:   e.Reason,
+			At:       time.Unix(e.Timestamp, 0),
+			Provider: ProviderSendGrid,
+		}
+		switch e.Event {
+		case "delivered":
+			event.Type = EventDelivered
+		case "deferred":
+			event.Type = EventDeferred
+		case "bounce":
+			event.Type = EventBounce
+			event.Permanent = e.Type != "blocked"
+		case "dropped":
+			event.Type = EventDropped
+			event.Permanent = sendGridPermanentDrops[e.Reason]
+		case "spamreport":
+			event.Type = EventComplaint
+		default:
+			// Opens, clicks and the like aren't delivery problems.
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
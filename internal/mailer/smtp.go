@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+var ErrStartTLSUnavailable = errors.New("smtp server does not offer STARTTLS")
+
+// SMTPMailer sends email through an SMTP relay. The connection is upgraded
+// with STARTTLS whenever the server offers it, and credentials are only
+// sent over TLS or to localhost.
+type SMTPMailer struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	RequireTLS bool
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	from, err := address(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := encode(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withDefaultDeadline(ctx)
+	defer cancel()
+
+	port := m.Port
+	if port == 0 {
+		port = 587
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	} else if m.RequireTLS {
+		return ErrStartTLSUnavailable
+	}
+	if m.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted
+		// connection to anything but localhost.
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,223 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an in-process SMTP server that accepts one message per
+// connection and records what it was sent.
+type fakeSMTP struct {
+	listener   net.Listener
+	extensions []string
+	received   chan fakeDelivery
+}
+
+type fakeDelivery struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T, extensions ...string) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener, extensions: extensions, received: make(chan fakeDelivery, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var delivery fakeDelivery
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := append([]string{"fake"}, s.extensions...)
+			for i, ext := range lines {
+				if i == len(lines)-1 {
+					reply("250 " + ext)
+				} else {
+					reply("250-" + ext)
+				}
+			}
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			delivery.auth = string(decoded)
+			reply("235 ok")
+		case "MAIL":
+			delivery.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			delivery.to = append(delivery.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			delivery.data = data.String()
+			s.received <- delivery
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	msg := Message{
+		From:    "Ed <ed@example.com>",
+		To:      "reader@example.com",
+		Subject: "Hello",
+		Text:    "Hi there",
+	}
+
+	tests := []struct {
+		name       string
+		extensions []string
+		mailer     SMTPMailer
+		wantErr    error
+		wantAuth   string
+	}{
+		{name: "plain delivery"},
+		{
+			name:       "authenticates to localhost",
+			extensions: []string{"AUTH PLAIN"},
+			mailer:     SMTPMailer{Username: "user", Password: "pass"},
+			wantAuth:   "\x00user\x00pass",
+		},
+		{
+			name:    "requires TLS the server doesn't offer",
+			mailer:  SMTPMailer{RequireTLS: true},
+			wantErr: ErrStartTLSUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t, tt.extensions...)
+			m := tt.mailer
+			m.Host = "127.0.0.1"
+			m.Port = server.port()
+
+			err := m.Send(context.Background(), msg)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Send() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			var got fakeDelivery
+			select {
+			case got = <-server.received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server received nothing")
+			}
+			if got.auth != tt.wantAuth {
+				t.Errorf("auth = %q, want %q", got.auth, tt.wantAuth)
+			}
+			if got.from != "ed@example.com" {
+				t.Errorf("MAIL FROM = %q, want ed@example.com", got.from)
+			}
+			if len(got.to) != 1 || got.to[0] != "reader@example.com" {
+				t.Errorf("RCPT TO = %q, want [reader@example.com]", got.to)
+			}
+			parsed, err := mail.ReadMessage(strings.NewReader(got.data))
+			if err != nil {
+				t.Fatalf("parsing delivered message: %v", err)
+			}
+			if subject := parsed.Header.Get("Subject"); subject != msg.Subject {
+				t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+			}
+		})
+	}
+}
+
+func TestSMTPMailerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	m := SMTPMailer{Host: "127.0.0.1", Port: port}
+	err = m.Send(context.Background(), Message{From: "a@example.com", To: "b@example.com", Text: "x"})
+	if err == nil {
+		t.Fatalf("Send() to closed port %s succeeded", strconv.Itoa(port))
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m, err := New(Options{Backend: BackendMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := m.(*MemoryMailer)
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := memory.Messages(); len(got) != 2 || got[0].To != "a@example.com" || got[1].To != "b@example.com" {
+		t.Errorf("Messages() = %+v, want both messages in order", got)
+	}
+	memory.Reset()
+	if got := memory.Messages(); len(got) != 0 {
+		t.Errorf("Messages() after Reset = %+v, want none", got)
+	}
+}
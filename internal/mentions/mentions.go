@@ -0,0 +1,89 @@
+// Package mentions finds @username mentions in text and turns them into
+// profile links.
+package mentions
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// MaxPerText caps how many distinct users one text can mention; further
+// mentions are left as plain text.
+const MaxPerText = 20
+
+// pattern matches "@name" where the @ does not follow a word character, so
+// email addresses are not mistaken for mentions. A trailing dot is not part
+// of the name, which lets a mention end a sentence.
+var pattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_][\p{L}\p{N}_.-]{0,62}[\p{L}\p{N}_]|[\p{L}\p{N}_])`)
+
+// Parse returns the distinct user names mentioned in text in order of first
+// appearance, compared case-insensitively, up to MaxPerText.
+func Parse(text string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+		name := match[2]
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+		if len(names) == MaxPerText {
+			break
+		}
+	}
+	return names
+}
+
+// Link rewrites the mentions in an HTML body as links. profiles maps a
+// lowercased user name to its profile URL; mentions of anyone else are left
+// alone, as is text that is already inside a link.
+func Link(body string, profiles map[string]string) string {
+	if len(profiles) == 0 {
+		return body
+	}
+
+	tokenizer := nethtml.NewTokenizer(strings.NewReader(body))
+	var out bytes.Buffer
+	inLink := 0
+	for {
+		tt := tokenizer.Next()
+		if tt == nethtml.ErrorToken {
+			return out.String()
+		}
+		raw := tokenizer.Raw()
+		switch tt {
+		case nethtml.StartTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "a" {
+				inLink++
+			}
+		case nethtml.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "a" && inLink > 0 {
+				inLink--
+			}
+		case nethtml.TextToken:
+			if inLink == 0 {
+				out.WriteString(linkText(string(raw), profiles))
+				continue
+			}
+		}
+		out.Write(raw)
+	}
+}
+
+// linkText links the mentions in a run of raw (still escaped) HTML text.
+func linkText(text string, profiles map[string]string) string {
+	return pattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := pattern.FindStringSubmatch(match)
+		profile, ok := profiles[strings.ToLower(groups[2])]
+		if !ok {
+			return match
+		}
+		return groups[1] + `<a href="` + html.EscapeString(profile) + `" class="mention">@` + groups[2] + `</a>`
+	})
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"goserver/internal/models"
+	"goserver/internal/realtime"
 	"net/http"
 	"os"
 	"strings"
@@ -19,36 +20,103 @@ func RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if !authenticate(c, secret, strings.TrimPrefix(authHeader, "Bearer ")) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		c.Next()
+	}
+}
+
+// RequireSocketAuth is RequireAuth for WebSocket upgrades. Browsers can't set
+// headers on a WebSocket handshake, so they pass a ticket from
+// POST /ws/ticket as the ticket query parameter instead. The token itself
+// never goes in the URL, where it would end up in logs.
+func RequireSocketAuth() gin.HandlerFunc {
+	secret := os.Getenv("JWT_SECRET")
+	return func(c *gin.Context) {
+		if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+			if !authenticate(c, secret, strings.TrimPrefix(authHeader, "Bearer ")) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				return
 			}
-			return []byte(secret), nil
-		})
+			c.Next()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header or ticket"})
+			return
+		}
+		client, ok := realtime.RedeemTicket(ticket)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
 			return
 		}
+		c.Set("userID", client.UserID)
+		c.Set("userName", client.UserName)
+		c.Set("roles", client.Role)
+		c.Set("tokenExpiresAt", client.ExpiresAt)
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Example: extract "roles" from claims
-			if roles, ok := claims["role"]; ok {
-				c.Set("roles", roles)
-			}
-			// You can also set user ID, email, etc. if present in claims
-			if userID, ok := claims["sub"]; ok {
-				c.Set("userID", userID)
-			}
+		c.Next()
+	}
+}
+
+// OptionalAuth identifies the caller when a bearer token is sent but lets
+// anonymous requests through. An invalid token is still rejected.
+func OptionalAuth() gin.HandlerFunc {
+	secret := os.Getenv("JWT_SECRET")
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
+			return
+		}
+
+		if !strings.HasPrefix(authHeader, "Bearer ") || !authenticate(c, secret, strings.TrimPrefix(authHeader, "Bearer ")) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
 		}
 
 		c.Next()
 	}
 }
 
+// authenticate validates the token and stores its claims on the context.
+func authenticate(c *gin.Context, secret, tokenString string) bool {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(secret), nil
+	})
+
+	if err != nil || !token.Valid {
+		return false
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		// Example: extract "roles" from claims
+		if roles, ok := claims["role"]; ok {
+			c.Set("roles", roles)
+		}
+		// You can also set user ID, email, etc. if present in claims
+		if userID, ok := claims["sub"]; ok {
+			c.Set("userID", userID)
+		}
+		if userName, ok := claims["user_name"]; ok {
+			c.Set("userName", userName)
+		}
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			c.Set("tokenExpiresAt", exp.Time)
+		}
+	}
+	return true
+}
+
 // RequireRole creates middleware that requires specific roles
 func RequireRole(allowedRoles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"log"
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,7 +22,7 @@ func Logger() gin.HandlerFunc {
 		statusCode := c.Writer.Status()
 
 		if raw != "" {
-			path = path + "?" + raw
+			path = path + "?" + redactQuery(raw)
 		}
 
 		log.Printf("%s %s %d %v %s",
@@ -33,3 +34,25 @@ func Logger() gin.HandlerFunc {
 		)
 	}
 }
+
+// redactedParams are query parameters that carry credentials.
+var redactedParams = []string{"token", "ticket"}
+
+// redactQuery hides the values of credential parameters in a raw query.
+func redactQuery(raw string) string {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "[unparsable query]"
+	}
+	redacted := false
+	for _, name := range redactedParams {
+		if _, ok := values[name]; ok {
+			values.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return raw
+	}
+	return values.Encode()
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions.
+const (
+	AuditEmailChanged       = "user.email_changed"
+	AuditEmailChangedAdmin  = "user.email_changed_by_admin"
+	AuditPasswordChanged    = "user.password_changed"
+	AuditInvitationCreated  = "invitation.created"
+	AuditInvitationRevoked  = "invitation.revoked"
+	AuditInvitationAccepted = "invitation.accepted"
+	AuditRoleRequested      = "role_request.created"
+	AuditRoleRequestDecided = "role_request.decided"
+	AuditRoleChanged        = "user.role_changed"
+)
+
+// AuditEntry records who did something sensitive to whom. Details holds
+// what changed, e.g. the old and new values.
+type AuditEntry struct {
+	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Action     string             `json:"action" bson:"action"`
+	ActorID    string             `json:"actor_id" bson:"actor_id"`
+	ActorName  string             `json:"actor_name,omitempty" bson:"actor_name,omitempty"`
+	TargetType string             `json:"target_type" bson:"target_type"`
+	TargetID   string             `json:"target_id" bson:"target_id"`
+	Details    map[string]string  `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
@@ -7,12 +7,19 @@ import (
 )
 
 type Blog struct {
-	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	Subject    string             `json:"blog_subject" bson:"blog_subject"`
-	OwnerName  string             `json:"blog_owner_name" bson:"blog_owner_name"`
-	OwnerEmail string             `json:"blog_owner_email" bson:"blog_owner_email"`
-	Body       string             `json:"blog_body" bson:"blog_body"`
-	Category   string             `json:"blog_category" bson:"blog_category"`
-	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID           primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	Subject      string              `json:"blog_subject" bson:"blog_subject"`
+	OwnerName    string              `json:"blog_owner_name" bson:"blog_owner_name"`
+	OwnerEmail   string              `json:"blog_owner_email" bson:"blog_owner_email"`
+	AuthorID     *primitive.ObjectID `json:"author_id,omitempty" bson:"author_id,omitempty"`
+	Body         string              `json:"blog_body" bson:"blog_body"`
+	Category     string              `json:"blog_category" bson:"blog_category"`
+	Mentions     []Mention           `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	Reactions    map[string]int64    `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	ViewCount    int64               `json:"view_count" bson:"view_count,omitempty"`
+	CommentCount int64               `json:"comment_count" bson:"comment_count"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time           `json:"updatedAt" bson:"updatedAt"`
+	DeletedAt    *time.Time          `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy    string              `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
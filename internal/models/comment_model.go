@@ -6,12 +6,56 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Comment is a comment on a blog post. Replies carry the IDs of every
+// comment above them in Ancestors (root first), and ThreadPath is that
+// chain joined with dots and ending in the comment's own ID, so sorting by
+// ThreadPath yields comments in thread order. ReactionTotal is the sum of
+// Reactions, kept so comments can be sorted by it. A deleted comment that still
+// has replies is kept as a Placeholder so the thread keeps its context.
 type Comment struct {
-	ID             primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	BlogID         primitive.ObjectID `json:"blog_id" bson:"blog_id"`
-	CommenterName  string             `json:"commenter_name" bson:"commenter_name"`
-	CommenterEmail string             `json:"commenter_email" bson:"commenter_email"`
-	CommentBody    string             `json:"comment_body" bson:"comment_body"`
-	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID             primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
+	BlogID         primitive.ObjectID   `json:"blog_id" bson:"blog_id"`
+	ParentID       *primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Ancestors      []primitive.ObjectID `json:"ancestors,omitempty" bson:"ancestors,omitempty"`
+	Depth          int                  `json:"depth" bson:"depth"`
+	ThreadPath     string               `json:"thread_path" bson:"thread_path"`
+	Placeholder    bool                 `json:"placeholder,omitempty" bson:"placeholder,omitempty"`
+	CommenterID    string               `json:"commenter_id,omitempty" bson:"commenter_id,omitempty"`
+	CommenterName  string               `json:"commenter_name" bson:"commenter_name"`
+	CommenterEmail string               `json:"commenter_email" bson:"commenter_email"`
+	CommentBody    string               `json:"comment_body" bson:"comment_body"`
+	Mentions       []Mention            `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	Edited         bool                 `json:"edited" bson:"edited,omitempty"`
+	EditedAt       *time.Time           `json:"editedAt,omitempty" bson:"editedAt,omitempty"`
+	EditHistory    []CommentEdit        `json:"edit_history,omitempty" bson:"edit_history,omitempty"`
+	Reactions      map[string]int64     `json:"reactions,omitempty" bson:"reactions,omitempty"`
+	ReactionTotal  int64                `json:"reaction_total" bson:"reaction_total"`
+	Status         string               `json:"status,omitempty" bson:"status,omitempty"`
+	ModeratedBy    string               `json:"moderatedBy,omitempty" bson:"moderatedBy,omitempty"`
+	ModeratedAt    *time.Time           `json:"moderatedAt,omitempty" bson:"moderatedAt,omitempty"`
+	SpamScore      float64              `json:"spam_score,omitempty" bson:"spam_score,omitempty"`
+	SpamReasons    []string             `json:"spam_reasons,omitempty" bson:"spam_reasons,omitempty"`
+	CreatedAt      time.Time            `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time            `json:"updatedAt" bson:"updatedAt"`
+	DeletedAt      *time.Time           `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy      string               `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
+
+// CommentEdit is an earlier version of an edited comment's body.
+type CommentEdit struct {
+	CommentBody string    `json:"comment_body" bson:"comment_body"`
+	EditedAt    time.Time `json:"editedAt" bson:"editedAt"`
+	EditedBy    string    `json:"editedBy,omitempty" bson:"editedBy,omitempty"`
+}
+
+// Comment moderation states. Comments stored before moderation existed have
+// no status and are treated as approved.
+const (
+	CommentStatusPending  = "pending"
+	CommentStatusApproved = "approved"
+	CommentStatusRejected = "rejected"
+	CommentStatusSpam     = "spam"
+)
+
+// DeletedCommentText replaces the author and body of a placeholder comment.
+const DeletedCommentText = "[deleted]"
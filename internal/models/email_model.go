@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outbox email states. Queued email waits for NextAttemptAt, and email that
+// has failed too many times is dead until an admin retries it. Email to a
+// suppressed address is never sent.
+const (
+	EmailStatusQueued     = "queued"
+	EmailStatusSending    = "sending"
+	EmailStatusSent       = "sent"
+	EmailStatusDead       = "dead"
+	EmailStatusSuppressed = "suppressed"
+)
+
+// OutboxEmail is an email waiting in, or delivered from, the outbox.
+type OutboxEmail struct {
+	ID primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	// IdempotencyKey stops the same email being queued twice, e.g. when a
+	// request is retried.
+	IdempotencyKey string            `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"`
+	To             string            `json:"to" bson:"to"`
+	Subject        string            `json:"subject" bson:"subject"`
+	Text           string            `json:"text,omitempty" bson:"text,omitempty"`
+	HTML           string            `json:"html,omitempty" bson:"html,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty" bson:"headers,omitempty"`
+	// Sensitive email carries a credential link, so its body and headers
+	// are hidden from the API and cleared once it is sent.
+	Sensitive     bool       `json:"sensitive,omitempty" bson:"sensitive,omitempty"`
+	Status        string     `json:"status" bson:"status"`
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	LastError     string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" bson:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt" bson:"updatedAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty" bson:"sentAt,omitempty"`
+}
+
+// EmailSuppression stops email being sent to an address that hard bounced
+// or whose owner reported us as spam. Type is the delivery event that
+// caused it.
+type EmailSuppression struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Email     string             `json:"email" bson:"email"`
+	Type      string             `json:"type" bson:"type"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Provider  string             `json:"provider,omitempty" bson:"provider,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
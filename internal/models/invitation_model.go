@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation states. A pending invitation whose ExpiresAt has passed is
+// reported as expired.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// Invitation brings someone in with a role chosen by the inviter, skipping
+// sign-up and email verification.
+type Invitation struct {
+	ID            primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	Email         string              `json:"email" bson:"email"`
+	Role          string              `json:"role" bson:"role"`
+	Message       string              `json:"message,omitempty" bson:"message,omitempty"`
+	InvitedBy     string              `json:"invited_by" bson:"invited_by"`
+	InvitedByName string              `json:"invited_by_name" bson:"invited_by_name"`
+	Status        string              `json:"status" bson:"status"`
+	ExpiresAt     time.Time           `json:"expiresAt" bson:"expiresAt"`
+	SentAt        time.Time           `json:"sentAt" bson:"sentAt"`
+	SendCount     int                 `json:"send_count" bson:"send_count"`
+	AcceptedAt    *time.Time          `json:"acceptedAt,omitempty" bson:"acceptedAt,omitempty"`
+	AcceptedBy    *primitive.ObjectID `json:"accepted_by,omitempty" bson:"accepted_by,omitempty"`
+	RevokedAt     *time.Time          `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	RevokedBy     string              `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mention is a user referred to as @username in a post or comment.
+type Mention struct {
+	UserID   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	UserName string             `json:"user_name" bson:"user_name"`
+}
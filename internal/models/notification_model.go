@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification events
+const (
+	NotificationMention         = "mention"
+	NotificationCommentOnPost   = "comment_on_post"
+	NotificationCommentReply    = "comment_reply"
+	NotificationCommentApproved = "comment_approved"
+	NotificationCommentPending  = "comment_pending"
+)
+
+// Notification channels
+const (
+	NotificationChannelInApp   = "in_app"
+	NotificationChannelEmail   = "email"
+	NotificationChannelWebhook = "webhook"
+)
+
+// NotificationEvents and NotificationChannels list what users can set
+// preferences for. A user with no preference for an event gets it in the
+// app and by email; webhooks are only used when asked for. An empty list
+// turns the event off.
+var (
+	NotificationEvents = []string{
+		NotificationMention, NotificationCommentOnPost, NotificationCommentReply,
+		NotificationCommentApproved, NotificationCommentPending,
+	}
+	NotificationChannels = []string{NotificationChannelInApp, NotificationChannelEmail, NotificationChannelWebhook}
+)
+
+// Notification tells a user about something that happened on the site.
+type Notification struct {
+	ID        primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	Type      string              `json:"type" bson:"type"`
+	ActorID   string              `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	ActorName string              `json:"actor_name,omitempty" bson:"actor_name,omitempty"`
+	BlogID    primitive.ObjectID  `json:"blog_id" bson:"blog_id"`
+	CommentID *primitive.ObjectID `json:"comment_id,omitempty" bson:"comment_id,omitempty"`
+	Title     string              `json:"title" bson:"title"`
+	Message   string              `json:"message" bson:"message"`
+	Link      string              `json:"link" bson:"link"`
+	Read      bool                `json:"read" bson:"read"`
+	ReadAt    *time.Time          `json:"readAt,omitempty" bson:"readAt,omitempty"`
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reaction targets
+const (
+	ReactionTargetBlog    = "blog"
+	ReactionTargetComment = "comment"
+)
+
+type Reaction struct {
+	ID         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	TargetType string             `json:"target_type" bson:"target_type"`
+	TargetID   primitive.ObjectID `json:"target_id" bson:"target_id"`
+	Type       string             `json:"reaction_type" bson:"reaction_type"`
+	UserID     string             `json:"user_id,omitempty" bson:"user_id"`
+	UserName   string             `json:"user_name,omitempty" bson:"user_name,omitempty"`
+	Anonymous  bool               `json:"anonymous,omitempty" bson:"anonymous,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+}
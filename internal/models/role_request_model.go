@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Role request states.
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+)
+
+// RoleRequest is a user asking an admin for a higher role.
+type RoleRequest struct {
+	ID            primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
+	UserName      string             `json:"user_name" bson:"user_name"`
+	CurrentRole   string             `json:"current_role" bson:"current_role"`
+	RequestedRole string             `json:"requested_role" bson:"requested_role"`
+	Justification string             `json:"justification" bson:"justification"`
+	Status        string             `json:"status" bson:"status"`
+	Reason        string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	DecidedBy     string             `json:"decided_by,omitempty" bson:"decided_by,omitempty"`
+	DecidedByName string             `json:"decided_by_name,omitempty" bson:"decided_by_name,omitempty"`
+	DecidedAt     *time.Time         `json:"decidedAt,omitempty" bson:"decidedAt,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a subscription follows. Category subscriptions name the category in
+// Target, author subscriptions hold the author's user ID.
+const (
+	SubscriptionScopeAll      = "all"
+	SubscriptionScopeCategory = "category"
+	SubscriptionScopeAuthor   = "author"
+)
+
+// How a subscriber hears about new posts.
+const (
+	DeliveryImmediate = "immediate"
+	DeliveryDaily     = "daily"
+	DeliveryWeekly    = "weekly"
+)
+
+// Subscription signs an email address up for new-post emails. Signed-in
+// users are confirmed straight away; anyone else must follow the link in a
+// confirmation email first.
+type Subscription struct {
+	ID     primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Email  string              `json:"email" bson:"email"`
+	Scope  string              `json:"scope" bson:"scope"`
+	Target string              `json:"target,omitempty" bson:"target"`
+	// TargetName is the author's user name, shown in place of their ID.
+	TargetName  string     `json:"target_name,omitempty" bson:"target_name,omitempty"`
+	Delivery    string     `json:"delivery" bson:"delivery"`
+	Confirmed   bool       `json:"confirmed" bson:"confirmed"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty" bson:"confirmedAt,omitempty"`
+	// LastDigestAt is when the last digest covered posts up to.
+	LastDigestAt *time.Time `json:"lastDigestAt,omitempty" bson:"lastDigestAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
@@ -20,14 +20,112 @@ var USER_ROLES = map[string]UserRole{
 }
 
 type User struct {
-	ID                primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	UserName          string             `json:"user_name" bson:"user_name"`
-	UserEmail         string             `json:"user_email,omitempty" bson:"user_email,omitempty"`
-	UserPassword      string             `json:"user_password,omitempty" bson:"user_password,omitempty"`
-	UserApproved      bool               `json:"user_approved,omitempty" bson:"user_approved,omitempty"`
-	UserVerifyCode    string             `json:"user_verify_code,omitempty" bson:"user_verify_code,omitempty"`
-	UserVerifyExpires time.Time          `json:"user_verify_expires" bson:"user_verify_expires,omitempty"`
-	Role              string             `json:"role" bson:"role"`
-	CreatedAt         time.Time          `json:"createdAt" bson:"createdAt,omitempty"`
-	UpdatedAt         time.Time          `json:"updatedAt" bson:"updatedAt,omitempty"`
+	ID                 primitive.ObjectID  `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserName           string              `json:"user_name" bson:"user_name"`
+	DisplayName        string              `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	Bio                string              `json:"bio,omitempty" bson:"bio,omitempty"`
+	AvatarURL          string              `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	Links              []ProfileLink       `json:"links,omitempty" bson:"links,omitempty"`
+	UserEmail          string              `json:"user_email,omitempty" bson:"user_email,omitempty"`
+	EmailUndeliverable bool                `json:"email_undeliverable,omitempty" bson:"email_undeliverable,omitempty"`
+	UserPassword       string              `json:"user_password,omitempty" bson:"user_password,omitempty"`
+	UserApproved       bool                `json:"user_approved,omitempty" bson:"user_approved,omitempty"`
+	UserVerifyCode     string              `json:"user_verify_code,omitempty" bson:"user_verify_code,omitempty"`
+	UserVerifyExpires  time.Time           `json:"user_verify_expires" bson:"user_verify_expires,omitempty"`
+	Role               string              `json:"role" bson:"role"`
+	NotificationPrefs  map[string][]string `json:"notification_prefs,omitempty" bson:"notification_prefs,omitempty"`
+	WebhookURL         string              `json:"webhook_url,omitempty" bson:"webhook_url,omitempty"`
+	WebhookSecret      string              `json:"-" bson:"webhook_secret,omitempty"`
+	Locale             string              `json:"locale,omitempty" bson:"locale,omitempty"`
+	CreatedAt          time.Time           `json:"createdAt" bson:"createdAt,omitempty"`
+	UpdatedAt          time.Time           `json:"updatedAt" bson:"updatedAt,omitempty"`
+	DeletedAt          *time.Time          `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy          string              `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+	DeletedContent     string              `json:"deletedContent,omitempty" bson:"deletedContent,omitempty"`
+}
+
+// ProfileLink is a link a user shows on their profile, such as their
+// website.
+type ProfileLink struct {
+	Label string `json:"label" bson:"label"`
+	URL   string `json:"url" bson:"url"`
+}
+
+// PublicUser is what anyone may see of a user.
+type PublicUser struct {
+	ID          primitive.ObjectID `json:"_id"`
+	UserName    string             `json:"user_name"`
+	DisplayName string             `json:"display_name,omitempty"`
+	Bio         string             `json:"bio,omitempty"`
+	AvatarURL   string             `json:"avatar_url,omitempty"`
+	Links       []ProfileLink      `json:"links,omitempty"`
+	Role        string             `json:"role"`
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+// Profile is a user's account as the user and admins see it: everything
+// but the password hash and verification code.
+type Profile struct {
+	PublicUser
+	UserEmail          string              `json:"user_email,omitempty"`
+	EmailUndeliverable bool                `json:"email_undeliverable,omitempty"`
+	UserApproved       bool                `json:"user_approved"`
+	Locale             string              `json:"locale,omitempty"`
+	NotificationPrefs  map[string][]string `json:"notification_prefs,omitempty"`
+	WebhookURL         string              `json:"webhook_url,omitempty"`
+	UpdatedAt          time.Time           `json:"updatedAt"`
+	DeletedAt          *time.Time          `json:"deletedAt,omitempty"`
+	DeletedBy          string              `json:"deletedBy,omitempty"`
+	DeletedContent     string              `json:"deletedContent,omitempty"`
+}
+
+// Public returns the user's public view.
+func (u *User) Public() PublicUser {
+	return PublicUser{
+		ID:          u.ID,
+		UserName:    u.UserName,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+		Links:       u.Links,
+		Role:        u.Role,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+// Profile returns the user's account without credentials.
+func (u *User) Profile() Profile {
+	return Profile{
+		PublicUser:         u.Public(),
+		UserEmail:          u.UserEmail,
+		EmailUndeliverable: u.EmailUndeliverable,
+		UserApproved:       u.UserApproved,
+		Locale:             u.Locale,
+		NotificationPrefs:  u.NotificationPrefs,
+		WebhookURL:         u.WebhookURL,
+		UpdatedAt:          u.UpdatedAt,
+		DeletedAt:          u.DeletedAt,
+		DeletedBy:          u.DeletedBy,
+		DeletedContent:     u.DeletedContent,
+	}
+}
+
+// Profiles returns the profiles of users.
+func Profiles(users []User) []Profile {
+	profiles := make([]Profile, 0, len(users))
+	for i := range users {
+		profiles = append(profiles, users[i].Profile())
+	}
+	return profiles
+}
+
+// EmailChange is a user's request to change their email address, applied
+// once they follow the link sent to the new address.
+type EmailChange struct {
+	ID        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	OldEmail  string             `json:"old_email" bson:"old_email"`
+	NewEmail  string             `json:"new_email" bson:"new_email"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 }
@@ -0,0 +1,100 @@
+// Package ratelimit provides a small in-memory fixed-window rate limiter.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows up to limit events per key in each window.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string]*window
+	lastSweep time.Time
+}
+
+// New returns a Limiter that allows limit events per key every period.
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		window:    period,
+		hits:      make(map[string]*window),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN records n events for key and reports whether they all fit within
+// the limit. Nothing is recorded when they do not.
+func (l *Limiter) AllowN(key string, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	w := l.current(key, now)
+	if w.count+n > l.limit {
+		return false
+	}
+	w.count += n
+	return true
+}
+
+// AllowAll records an event for every key and reports whether each of them
+// is within its limit. Nothing is recorded unless they all are, so a key
+// isn't charged for an event another key refused.
+func (l *Limiter) AllowAll(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	windows := make([]*window, 0, len(keys))
+	for _, key := range keys {
+		w := l.current(key, now)
+		if w.count+1 > l.limit {
+			return false
+		}
+		windows = append(windows, w)
+	}
+	for _, w := range windows {
+		w.count++
+	}
+	return true
+}
+
+// current returns key's window, starting a new one if the last has ended.
+func (l *Limiter) current(key string, now time.Time) *window {
+	w, ok := l.hits[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &window{start: now}
+		l.hits[key] = w
+	}
+	return w
+}
+
+// sweep drops expired windows so idle keys don't accumulate forever.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, w := range l.hits {
+		if now.Sub(w.start) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+	l.lastSweep = now
+}
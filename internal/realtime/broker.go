@@ -0,0 +1,99 @@
+// Package realtime fans out live events, such as new comments, to the
+// clients subscribed to them.
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// TopicPosts carries an event for every newly published post.
+const TopicPosts = "posts"
+
+// BlogTopic returns the topic carrying the comment events of one blog.
+func BlogTopic(blogID string) string {
+	return "blog:" + blogID
+}
+
+// PresenceTopic returns the topic carrying presence and typing signals for
+// topic. They are kept apart so that anonymous comment streams never see who
+// is online.
+func PresenceTopic(topic string) string {
+	return "presence:" + topic
+}
+
+// Event types
+const (
+	EventCommentCreated = "comment.created"
+	EventCommentUpdated = "comment.updated"
+	EventCommentDeleted = "comment.deleted"
+	EventPostCreated    = "post.created"
+	EventPresence       = "presence"
+	EventTyping         = "typing"
+	// EventReset tells a resuming subscriber that events were missed and
+	// it should reload instead.
+	EventReset = "reset"
+)
+
+// Event is one message published on a topic. IDs are opaque strings that
+// increase within a broker, so they can be handed back to Subscribe to
+// resume after a disconnect.
+type Event struct {
+	ID    string          `json:"id,omitempty"`
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+	Time  time.Time       `json:"time"`
+}
+
+// Subscription delivers the events of one topic. Events is closed when the
+// subscription is closed or when the broker drops a subscriber that falls
+// too far behind; the client can then resubscribe from the last event it saw.
+type Subscription interface {
+	Events() <-chan Event
+	Close()
+}
+
+// Broker is a publish/subscribe hub. The in-memory implementation only
+// reaches subscribers in the same process; one backed by Mongo change
+// streams could serve several instances behind the same interface.
+type Broker interface {
+	Publish(topic, eventType string, data interface{}) error
+	// Subscribe starts a subscription to topic. With a lastEventID, events
+	// published after it are replayed first, or a single EventReset event
+	// is sent if they are no longer available.
+	Subscribe(topic, lastEventID string) (Subscription, error)
+	// Signal delivers a transient event, such as a typing indicator, to the
+	// current subscribers only. It has no ID and is never replayed.
+	Signal(topic, eventType string, data interface{}) error
+	// Close ends every subscription and refuses new ones, so that open
+	// streams finish when the server shuts down.
+	Close()
+}
+
+var ErrBrokerClosed = errors.New("realtime broker is closed")
+
+// DefaultBroker is the broker the services publish to.
+var DefaultBroker Broker
+
+// InitBroker sets up an in-memory DefaultBroker that keeps historySize
+// events for resuming and buffers up to bufferSize events per subscriber.
+func InitBroker(historySize, bufferSize int) {
+	DefaultBroker = NewMemoryBroker(historySize, bufferSize)
+}
+
+// Shutdown closes DefaultBroker, if there is one.
+func Shutdown() {
+	if DefaultBroker != nil {
+		DefaultBroker.Close()
+	}
+}
+
+// Publish publishes on DefaultBroker. It does nothing if no broker is set.
+func Publish(topic, eventType string, data interface{}) error {
+	if DefaultBroker == nil {
+		return nil
+	}
+	return DefaultBroker.Publish(topic, eventType, data)
+}
@@ -0,0 +1,203 @@
+package realtime
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is a Broker for a single process. It keeps a ring of recent
+// events across all topics for Last-Event-ID resume.
+type MemoryBroker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event
+	historyNext int
+	historyFull bool
+	bufferSize  int
+	subscribers map[string]map[*memorySubscription]bool
+	closed      bool
+}
+
+// NewMemoryBroker returns a MemoryBroker that remembers historySize events
+// and buffers bufferSize events per subscriber.
+func NewMemoryBroker(historySize, bufferSize int) *MemoryBroker {
+	if historySize < 1 {
+		historySize = 1
+	}
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &MemoryBroker{
+		history:     make([]Event, historySize),
+		bufferSize:  bufferSize,
+		subscribers: make(map[string]map[*memorySubscription]bool),
+	}
+}
+
+type memorySubscription struct {
+	broker *MemoryBroker
+	topic  string
+	events chan Event
+	closed bool
+}
+
+func (s *memorySubscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *memorySubscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// Publish records an event in the history and sends it to every subscriber
+// of topic.
+func (b *MemoryBroker) Publish(topic, eventType string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{
+		ID:    strconv.FormatUint(b.lastID, 10),
+		Topic: topic,
+		Type:  eventType,
+		Data:  raw,
+		Time:  time.Now(),
+	}
+	b.history[b.historyNext] = event
+	b.historyNext = (b.historyNext + 1) % len(b.history)
+	if b.historyNext == 0 {
+		b.historyFull = true
+	}
+	b.deliver(event)
+	return nil
+}
+
+// Signal implements Broker.
+func (b *MemoryBroker) Signal(topic, eventType string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliver(Event{Topic: topic, Type: eventType, Data: raw, Time: time.Now()})
+	return nil
+}
+
+// deliver sends event to every subscriber of its topic. Subscribers whose
+// buffer is full are dropped rather than allowed to hold up the others.
+// b.mu must be held.
+func (b *MemoryBroker) deliver(event Event) {
+	for sub := range b.subscribers[event.Topic] {
+		select {
+		case sub.events <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribe implements Broker.
+func (b *MemoryBroker) Subscribe(topic, lastEventID string) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+
+	var replay []Event
+	if lastEventID != "" {
+		replay = b.replay(topic, lastEventID)
+	}
+
+	sub := &memorySubscription{
+		broker: b,
+		topic:  topic,
+		events: make(chan Event, b.bufferSize+len(replay)),
+	}
+	for _, event := range replay {
+		sub.events <- event
+	}
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[*memorySubscription]bool)
+	}
+	b.subscribers[topic][sub] = true
+	return sub, nil
+}
+
+// Close implements Broker.
+func (b *MemoryBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subs := range b.subscribers {
+		for sub := range subs {
+			b.remove(sub)
+		}
+	}
+}
+
+// replay returns the events on topic published after lastEventID, or a
+// reset event when some of them have already left the history.
+func (b *MemoryBroker) replay(topic, lastEventID string) []Event {
+	last, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil || last > b.lastID {
+		return []Event{b.resetEvent(topic)}
+	}
+	if last == b.lastID {
+		return nil
+	}
+
+	oldest := b.history[0]
+	if b.historyFull {
+		oldest = b.history[b.historyNext]
+	}
+	if oldestID, _ := strconv.ParseUint(oldest.ID, 10, 64); last+1 < oldestID {
+		return []Event{b.resetEvent(topic)}
+	}
+
+	var events []Event
+	for i := 0; i < len(b.history); i++ {
+		event := b.history[(b.historyNext+i)%len(b.history)]
+		if event.ID == "" || event.Topic != topic {
+			continue
+		}
+		if id, _ := strconv.ParseUint(event.ID, 10, 64); id > last {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (b *MemoryBroker) resetEvent(topic string) Event {
+	return Event{
+		ID:    strconv.FormatUint(b.lastID, 10),
+		Topic: topic,
+		Type:  EventReset,
+		Data:  json.RawMessage("{}"),
+		Time:  time.Now(),
+	}
+}
+
+// remove unregisters sub and closes its channel. b.mu must be held.
+func (b *MemoryBroker) remove(sub *memorySubscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	delete(b.subscribers[sub.topic], sub)
+	if len(b.subscribers[sub.topic]) == 0 {
+		delete(b.subscribers, sub.topic)
+	}
+}
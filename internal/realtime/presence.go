@@ -0,0 +1,174 @@
+package realtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PresenceMember is one user present on a topic. A user with several
+// connections is listed once, as editing if any of them is.
+type PresenceMember struct {
+	UserID   string    `json:"user_id"`
+	UserName string    `json:"user_name"`
+	Editing  bool      `json:"editing"`
+	Since    time.Time `json:"since"`
+}
+
+type presenceEntry struct {
+	member PresenceMember
+	seen   time.Time
+}
+
+// Presence tracks which sessions are present on which topics. Entries that
+// are not touched within the timeout expire, so a connection that silently
+// died does not linger in the member list.
+type Presence struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	topics  map[string]map[string]*presenceEntry
+	// sessions indexes the topics each session is present on.
+	sessions map[string]map[string]bool
+}
+
+// NewPresence returns a Presence that expires entries after timeout.
+func NewPresence(timeout time.Duration) *Presence {
+	return &Presence{
+		timeout:  timeout,
+		topics:   make(map[string]map[string]*presenceEntry),
+		sessions: make(map[string]map[string]bool),
+	}
+}
+
+// Set adds or updates the session's entry on topic and reports whether the
+// topic's member list changed.
+func (p *Presence) Set(topic, sessionID string, member PresenceMember) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	before := p.members(topic)
+	now := time.Now()
+	if p.topics[topic] == nil {
+		p.topics[topic] = make(map[string]*presenceEntry)
+	}
+	if entry, ok := p.topics[topic][sessionID]; ok {
+		member.Since = entry.member.Since
+	} else {
+		member.Since = now
+	}
+	p.topics[topic][sessionID] = &presenceEntry{member: member, seen: now}
+	if p.sessions[sessionID] == nil {
+		p.sessions[sessionID] = make(map[string]bool)
+	}
+	p.sessions[sessionID][topic] = true
+	return !sameMembers(before, p.members(topic))
+}
+
+// Touch keeps all of the session's entries alive.
+func (p *Presence) Touch(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for topic := range p.sessions[sessionID] {
+		if entry, ok := p.topics[topic][sessionID]; ok {
+			entry.seen = now
+		}
+	}
+}
+
+// Remove drops the session's entry on topic and reports whether the
+// topic's member list changed.
+func (p *Presence) Remove(topic, sessionID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.topics[topic][sessionID]; !ok {
+		return false
+	}
+	before := p.members(topic)
+	p.remove(topic, sessionID)
+	return !sameMembers(before, p.members(topic))
+}
+
+// Expire drops entries that have not been touched within the timeout and
+// returns the topics whose member lists changed.
+func (p *Presence) Expire() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cutoff := time.Now().Add(-p.timeout)
+	var changed []string
+	for topic, entries := range p.topics {
+		before := p.members(topic)
+		for sessionID, entry := range entries {
+			if entry.seen.Before(cutoff) {
+				p.remove(topic, sessionID)
+			}
+		}
+		if !sameMembers(before, p.members(topic)) {
+			changed = append(changed, topic)
+		}
+	}
+	return changed
+}
+
+// Members lists the users present on topic, ordered by user name.
+func (p *Presence) Members(topic string) []PresenceMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.members(topic)
+}
+
+// members implements Members. p.mu must be held.
+func (p *Presence) members(topic string) []PresenceMember {
+	byUser := map[string]*PresenceMember{}
+	for _, entry := range p.topics[topic] {
+		member := entry.member
+		if existing, ok := byUser[member.UserID]; ok {
+			existing.Editing = existing.Editing || member.Editing
+			if member.Since.Before(existing.Since) {
+				existing.Since = member.Since
+			}
+			continue
+		}
+		byUser[member.UserID] = &member
+	}
+
+	members := make([]PresenceMember, 0, len(byUser))
+	for _, member := range byUser {
+		members = append(members, *member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].UserName != members[j].UserName {
+			return members[i].UserName < members[j].UserName
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members
+}
+
+// remove deletes one entry and its index. p.mu must be held.
+func (p *Presence) remove(topic, sessionID string) {
+	delete(p.topics[topic], sessionID)
+	if len(p.topics[topic]) == 0 {
+		delete(p.topics, topic)
+	}
+	delete(p.sessions[sessionID], topic)
+	if len(p.sessions[sessionID]) == 0 {
+		delete(p.sessions, sessionID)
+	}
+}
+
+// sameMembers compares member lists, ignoring when each was last seen.
+func sameMembers(a, b []PresenceMember) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,503 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"goserver/internal/ratelimit"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// socketWriteWait is how long a single write to a client may take.
+	socketWriteWait = 10 * time.Second
+	// socketSendBuffer is how many outgoing messages a connection may have
+	// queued before it is closed as too slow.
+	socketSendBuffer = 64
+)
+
+var (
+	ErrTooManyConnections = errors.New("too many live connections")
+	ErrUnknownChannel     = errors.New("unknown channel")
+	ErrChannelForbidden   = errors.New("not allowed on this channel")
+)
+
+// SocketLimits bounds what WebSocket clients may do. Zero means no limit.
+type SocketLimits struct {
+	MaxConnections        int
+	MaxConnectionsPerUser int
+	// MaxSubscriptions is the number of channels one connection may follow.
+	MaxSubscriptions int
+	MaxMessageSize   int64
+	// Each user may send MessageLimit messages per MessageWindow across all
+	// of their connections.
+	MessageLimit  int
+	MessageWindow time.Duration
+	// PingInterval must be shorter than PresenceTimeout, since answered
+	// pings are what keep an idle client present.
+	PingInterval    time.Duration
+	PresenceTimeout time.Duration
+}
+
+// SocketClient identifies the authenticated user behind a connection. The
+// connection is closed at ExpiresAt, when the token it was opened with
+// runs out, unless ExpiresAt is zero.
+type SocketClient struct {
+	UserID    string
+	UserName  string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// ChannelAccess describes what a client may do on a channel it has been
+// allowed to subscribe to.
+type ChannelAccess struct {
+	Topic string
+	// Presence puts the client in, and shows it, the channel's member list.
+	Presence bool
+	Typing   bool
+	Editing  bool
+}
+
+// Authorizer decides whether client may subscribe to channel. It returns
+// ErrUnknownChannel or ErrChannelForbidden to refuse.
+type Authorizer func(client SocketClient, channel string) (ChannelAccess, error)
+
+// socketRequest is a message from a client.
+//
+//	{"type": "subscribe", "channel": "blog:<id>", "last_event_id": "42"}
+//	{"type": "unsubscribe", "channel": "blog:<id>"}
+//	{"type": "typing", "channel": "blog:<id>", "typing": true}
+//	{"type": "presence", "channel": "blog:<id>", "editing": true}
+//	{"type": "ping"}
+type socketRequest struct {
+	Type        string `json:"type"`
+	Channel     string `json:"channel,omitempty"`
+	LastEventID string `json:"last_event_id,omitempty"`
+	Typing      bool   `json:"typing,omitempty"`
+	Editing     bool   `json:"editing,omitempty"`
+}
+
+// socketMessage is a message to a client: subscribed, unsubscribed, event,
+// error or pong. Events carry both published events and the presence and
+// typing signals of the channel.
+type socketMessage struct {
+	Type    string      `json:"type"`
+	Channel string      `json:"channel,omitempty"`
+	Event   *Event      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// presenceData is the payload of a presence event.
+type presenceData struct {
+	Members []PresenceMember `json:"members"`
+}
+
+// typingData is the payload of a typing event. Clients should hide the
+// indicator if it is not repeated within a few seconds.
+type typingData struct {
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Typing   bool   `json:"typing"`
+}
+
+// Hub serves WebSocket connections on top of a Broker, adding presence,
+// typing indicators and per-channel authorization.
+type Hub struct {
+	broker    Broker
+	authorize Authorizer
+	limits    SocketLimits
+	presence  *Presence
+	limiter   *ratelimit.Limiter
+
+	mu      sync.Mutex
+	total   int
+	perUser map[string]int
+	lastID  uint64
+}
+
+// DefaultHub serves the WebSocket endpoint.
+var DefaultHub *Hub
+
+// InitHub sets up DefaultHub on DefaultBroker.
+func InitHub(authorize Authorizer, limits SocketLimits) {
+	DefaultHub = NewHub(DefaultBroker, authorize, limits)
+}
+
+// NewHub returns a Hub and starts expiring stale presence in the background.
+func NewHub(broker Broker, authorize Authorizer, limits SocketLimits) *Hub {
+	if limits.PingInterval <= 0 {
+		limits.PingInterval = 25 * time.Second
+	}
+	if limits.PresenceTimeout <= 0 {
+		limits.PresenceTimeout = 2 * limits.PingInterval
+	}
+	h := &Hub{
+		broker:    broker,
+		authorize: authorize,
+		limits:    limits,
+		presence:  NewPresence(limits.PresenceTimeout),
+		perUser:   make(map[string]int),
+	}
+	if limits.MessageLimit > 0 && limits.MessageWindow > 0 {
+		h.limiter = ratelimit.New(limits.MessageLimit, limits.MessageWindow)
+	}
+	go h.expirePresence()
+	return h
+}
+
+// Admit reserves a connection slot for the user. The returned release must
+// be called once the connection ends.
+func (h *Hub) Admit(userID string) (func(), error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.limits.MaxConnections > 0 && h.total >= h.limits.MaxConnections {
+		return nil, ErrTooManyConnections
+	}
+	if h.limits.MaxConnectionsPerUser > 0 && h.perUser[userID] >= h.limits.MaxConnectionsPerUser {
+		return nil, ErrTooManyConnections
+	}
+	h.total++
+	h.perUser[userID]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.total--
+			if h.perUser[userID]--; h.perUser[userID] <= 0 {
+				delete(h.perUser, userID)
+			}
+		})
+	}, nil
+}
+
+// Serve runs the protocol on conn until the client disconnects.
+func (h *Hub) Serve(conn *websocket.Conn, client SocketClient) {
+	h.mu.Lock()
+	h.lastID++
+	id := strconv.FormatUint(h.lastID, 10)
+	h.mu.Unlock()
+
+	s := &socketSession{
+		hub:      h,
+		id:       id,
+		client:   client,
+		conn:     conn,
+		send:     make(chan socketMessage, socketSendBuffer),
+		done:     make(chan struct{}),
+		channels: make(map[string]*socketChannel),
+	}
+	defer s.shutdown()
+	go s.writeLoop()
+	s.readLoop()
+}
+
+// broadcastPresence sends topic's member list to its subscribers.
+func (h *Hub) broadcastPresence(topic string) {
+	h.broker.Signal(PresenceTopic(topic), EventPresence, presenceData{Members: h.presence.Members(topic)})
+}
+
+func (h *Hub) expirePresence() {
+	ticker := time.NewTicker(h.limits.PresenceTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, topic := range h.presence.Expire() {
+			h.broadcastPresence(topic)
+		}
+	}
+}
+
+// socketChannel is one channel a connection follows.
+type socketChannel struct {
+	access ChannelAccess
+	subs   []Subscription
+}
+
+type socketSession struct {
+	hub    *Hub
+	id     string
+	client SocketClient
+	conn   *websocket.Conn
+	send   chan socketMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	channels map[string]*socketChannel
+}
+
+func (s *socketSession) readLoop() {
+	pongWait := 2 * s.hub.limits.PingInterval
+	if s.hub.limits.MaxMessageSize > 0 {
+		s.conn.SetReadLimit(s.hub.limits.MaxMessageSize)
+	}
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		s.hub.presence.Touch(s.id)
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(pongWait))
+		s.hub.presence.Touch(s.id)
+
+		var req socketRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.queue(socketMessage{Type: "error", Error: "Invalid message"})
+			continue
+		}
+		if s.hub.limiter != nil && !s.hub.limiter.Allow(s.client.UserID) {
+			s.queue(socketMessage{Type: "error", Channel: req.Channel, Error: "Too many messages, slow down"})
+			continue
+		}
+		s.handle(req)
+	}
+}
+
+func (s *socketSession) handle(req socketRequest) {
+	var err error
+	switch req.Type {
+	case "subscribe":
+		err = s.subscribe(req.Channel, req.LastEventID)
+	case "unsubscribe":
+		s.unsubscribe(req.Channel, nil)
+		s.queue(socketMessage{Type: "unsubscribed", Channel: req.Channel})
+	case "typing":
+		err = s.typing(req.Channel, req.Typing)
+	case "presence":
+		err = s.setEditing(req.Channel, req.Editing)
+	case "ping":
+		s.queue(socketMessage{Type: "pong"})
+	default:
+		err = errors.New("unknown message type")
+	}
+	if err != nil {
+		s.queue(socketMessage{Type: "error", Channel: req.Channel, Error: err.Error()})
+	}
+}
+
+func (s *socketSession) subscribe(channel, lastEventID string) error {
+	s.mu.Lock()
+	_, subscribed := s.channels[channel]
+	count := len(s.channels)
+	s.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	if max := s.hub.limits.MaxSubscriptions; max > 0 && count >= max {
+		return errors.New("too many subscriptions")
+	}
+
+	access, err := s.hub.authorize(s.client, channel)
+	if err != nil {
+		return err
+	}
+	ch := &socketChannel{access: access}
+	sub, err := s.hub.broker.Subscribe(access.Topic, lastEventID)
+	if err != nil {
+		return err
+	}
+	ch.subs = append(ch.subs, sub)
+	if access.Presence {
+		sub, err := s.hub.broker.Subscribe(PresenceTopic(access.Topic), "")
+		if err != nil {
+			ch.close()
+			return err
+		}
+		ch.subs = append(ch.subs, sub)
+	}
+
+	s.mu.Lock()
+	if _, raced := s.channels[channel]; raced {
+		s.mu.Unlock()
+		ch.close()
+		return nil
+	}
+	s.channels[channel] = ch
+	s.mu.Unlock()
+
+	var data interface{}
+	if access.Presence {
+		changed := s.hub.presence.Set(access.Topic, s.id, PresenceMember{UserID: s.client.UserID, UserName: s.client.UserName})
+		data = presenceData{Members: s.hub.presence.Members(access.Topic)}
+		if changed {
+			s.hub.broadcastPresence(access.Topic)
+		}
+	}
+	s.queue(socketMessage{Type: "subscribed", Channel: channel, Data: data})
+	for _, sub := range ch.subs {
+		go s.forward(channel, ch, sub)
+	}
+	return nil
+}
+
+// unsubscribe stops following channel. With a non-nil only, it does so
+// only if channel is still that subscription, so a stale forwarder can't
+// end a newer one.
+func (s *socketSession) unsubscribe(channel string, only *socketChannel) {
+	s.mu.Lock()
+	ch, ok := s.channels[channel]
+	if !ok || (only != nil && ch != only) {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.channels, channel)
+	s.mu.Unlock()
+
+	ch.close()
+	if ch.access.Presence && s.hub.presence.Remove(ch.access.Topic, s.id) {
+		s.hub.broadcastPresence(ch.access.Topic)
+	}
+}
+
+// forward relays one subscription's events until it ends. If the broker
+// drops it for falling behind, the client is told so that it can
+// resubscribe from the last event it saw.
+func (s *socketSession) forward(channel string, ch *socketChannel, sub Subscription) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				s.mu.Lock()
+				current := s.channels[channel] == ch
+				s.mu.Unlock()
+				if current {
+					s.unsubscribe(channel, ch)
+					s.queue(socketMessage{Type: "unsubscribed", Channel: channel, Error: "Fell too far behind, resubscribe with last_event_id"})
+				}
+				return
+			}
+			s.queue(socketMessage{Type: "event", Channel: channel, Event: &event})
+		}
+	}
+}
+
+func (s *socketSession) channel(name string) (*socketChannel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[name]
+	if !ok {
+		return nil, errors.New("not subscribed to this channel")
+	}
+	return ch, nil
+}
+
+func (s *socketSession) typing(channel string, typing bool) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	if !ch.access.Typing {
+		return ErrChannelForbidden
+	}
+	return s.hub.broker.Signal(PresenceTopic(ch.access.Topic), EventTyping, typingData{
+		UserID:   s.client.UserID,
+		UserName: s.client.UserName,
+		Typing:   typing,
+	})
+}
+
+func (s *socketSession) setEditing(channel string, editing bool) error {
+	ch, err := s.channel(channel)
+	if err != nil {
+		return err
+	}
+	if !ch.access.Presence || (editing && !ch.access.Editing) {
+		return ErrChannelForbidden
+	}
+	member := PresenceMember{UserID: s.client.UserID, UserName: s.client.UserName, Editing: editing}
+	if s.hub.presence.Set(ch.access.Topic, s.id, member) {
+		s.hub.broadcastPresence(ch.access.Topic)
+	}
+	return nil
+}
+
+func (s *socketSession) writeLoop() {
+	ticker := time.NewTicker(s.hub.limits.PingInterval)
+	defer ticker.Stop()
+	var expired <-chan time.Time
+	if !s.client.ExpiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(s.client.ExpiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-expired:
+			s.close(websocket.ClosePolicyViolation, "Token expired")
+			return
+		case msg := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// queue sends msg without blocking. A client that can't keep up is
+// disconnected.
+func (s *socketSession) queue(msg socketMessage) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.send <- msg:
+	default:
+		s.close(websocket.CloseTryAgainLater, "Too slow to keep up")
+	}
+}
+
+func (s *socketSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(socketWriteWait))
+		s.conn.Close()
+	})
+}
+
+// shutdown closes the connection and leaves every channel.
+func (s *socketSession) shutdown() {
+	s.close(websocket.CloseNormalClosure, "")
+
+	s.mu.Lock()
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		s.unsubscribe(name, nil)
+	}
+}
+
+func (ch *socketChannel) close() {
+	for _, sub := range ch.subs {
+		sub.Close()
+	}
+}
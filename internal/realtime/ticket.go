@@ -0,0 +1,62 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TicketTTL is how long a socket ticket can be redeemed for.
+const TicketTTL = 30 * time.Second
+
+// Browsers can't set headers on a WebSocket handshake, so clients trade
+// their bearer token for a ticket over an authenticated request and pass
+// the ticket in the socket URL instead. A ticket works once and only
+// briefly, so it is harmless once it has been logged.
+var tickets = struct {
+	sync.Mutex
+	issued map[string]issuedTicket
+}{
+	issued: map[string]issuedTicket{},
+}
+
+type issuedTicket struct {
+	client  SocketClient
+	expires time.Time
+}
+
+// IssueTicket returns a single-use ticket that opens a socket as client.
+func IssueTicket(client SocketClient) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	now := time.Now()
+	tickets.Lock()
+	defer tickets.Unlock()
+	for t, issued := range tickets.issued {
+		if now.After(issued.expires) {
+			delete(tickets.issued, t)
+		}
+	}
+	tickets.issued[ticket] = issuedTicket{client: client, expires: now.Add(TicketTTL)}
+	return ticket, nil
+}
+
+// RedeemTicket returns the client a ticket was issued for and uses it up.
+func RedeemTicket(ticket string) (SocketClient, bool) {
+	tickets.Lock()
+	defer tickets.Unlock()
+	issued, ok := tickets.issued[ticket]
+	if !ok {
+		return SocketClient{}, false
+	}
+	delete(tickets.issued, ticket)
+	if time.Now().After(issued.expires) {
+		return SocketClient{}, false
+	}
+	return issued.client, true
+}
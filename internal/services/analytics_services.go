@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// analyticsTopN is how many referrers and posts the rankings include.
+const analyticsTopN = 10
+
+type DailyViews struct {
+	Day   string `json:"day" bson:"_id"`
+	Views int64  `json:"views" bson:"views"`
+}
+
+type ReferrerViews struct {
+	Referrer string `json:"referrer" bson:"_id"`
+	Views    int64  `json:"views" bson:"views"`
+}
+
+type PostViews struct {
+	BlogID  primitive.ObjectID `json:"blog_id" bson:"_id"`
+	Subject string             `json:"blog_subject" bson:"-"`
+	Views   int64              `json:"views" bson:"views"`
+}
+
+// ViewStats summarizes views over an inclusive range of days.
+type ViewStats struct {
+	From         string          `json:"from"`
+	To           string          `json:"to"`
+	TotalViews   int64           `json:"total_views"`
+	Daily        []DailyViews    `json:"daily"`
+	TopReferrers []ReferrerViews `json:"top_referrers"`
+	TopPosts     []PostViews     `json:"top_posts,omitempty"`
+}
+
+// SiteTotals are all-time counts across the whole site.
+type SiteTotals struct {
+	Blogs    int64 `json:"blogs"`
+	Comments int64 `json:"comments"`
+	Users    int64 `json:"users"`
+	Views    int64 `json:"views"`
+}
+
+// GetBlogViewStats returns view statistics for a single post.
+func GetBlogViewStats(blogID primitive.ObjectID, from, to time.Time) (*ViewStats, error) {
+	return getViewStats(bson.M{"blog_id": blogID}, from, to, false)
+}
+
+// GetAuthorViewStats returns view statistics across every post by an author,
+// including the author's most viewed posts.
+func GetAuthorViewStats(authorID, authorName string, from, to time.Time) (*ViewStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ids, err := blogIDsOwnedBy(ctx, authorID, authorName)
+	if err != nil {
+		return nil, err
+	}
+	return getViewStats(bson.M{"blog_id": bson.M{"$in": ids}}, from, to, true)
+}
+
+// GetSiteViewStats returns view statistics across the whole site.
+func GetSiteViewStats(from, to time.Time) (*ViewStats, error) {
+	return getViewStats(bson.M{}, from, to, true)
+}
+
+// GetSiteTotals counts live blogs, comments and users and all recorded views.
+func GetSiteTotals() (*SiteTotals, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	totals := &SiteTotals{}
+	var err error
+	if totals.Blogs, err = getCollection("blogs").CountDocuments(ctx, notDeleted(bson.M{})); err != nil {
+		return nil, err
+	}
+	if totals.Comments, err = getCollection("comments").CountDocuments(ctx, notDeleted(bson.M{})); err != nil {
+		return nil, err
+	}
+	if totals.Users, err = getCollection("users").CountDocuments(ctx, notDeleted(bson.M{})); err != nil {
+		return nil, err
+	}
+
+	cursor, err := getCollection("blog_views_daily").Aggregate(ctx, bson.A{
+		bson.M{"$group": bson.M{"_id": nil, "views": bson.M{"$sum": "$views"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sums []struct {
+		Views int64 `bson:"views"`
+	}
+	if err := cursor.All(ctx, &sums); err != nil {
+		return nil, err
+	}
+	if len(sums) > 0 {
+		totals.Views = sums[0].Views
+	}
+	return totals, nil
+}
+
+func getViewStats(scope bson.M, from, to time.Time, withTopPosts bool) (*ViewStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stats := &ViewStats{
+		From:         from.UTC().Format(dayFormat),
+		To:           to.UTC().Format(dayFormat),
+		Daily:        []DailyViews{},
+		TopReferrers: []ReferrerViews{},
+	}
+
+	match := bson.M{"day": bson.M{"$gte": stats.From, "$lte": stats.To}}
+	for key, value := range scope {
+		match[key] = value
+	}
+
+	daily := getCollection("blog_views_daily")
+	cursor, err := daily.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": "$day", "views": bson.M{"$sum": "$views"}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &stats.Daily); err != nil {
+		return nil, err
+	}
+	for _, day := range stats.Daily {
+		stats.TotalViews += day.Views
+	}
+
+	cursor, err = getCollection("blog_referrers_daily").Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": "$referrer", "views": bson.M{"$sum": "$views"}}},
+		bson.M{"$sort": bson.D{{Key: "views", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$limit": analyticsTopN},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &stats.TopReferrers); err != nil {
+		return nil, err
+	}
+
+	if !withTopPosts {
+		return stats, nil
+	}
+
+	stats.TopPosts = []PostViews{}
+	cursor, err = daily.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": "$blog_id", "views": bson.M{"$sum": "$views"}}},
+		bson.M{"$sort": bson.D{{Key: "views", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$limit": analyticsTopN},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &stats.TopPosts); err != nil {
+		return nil, err
+	}
+	if err := fillPostSubjects(ctx, stats.TopPosts); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func fillPostSubjects(ctx context.Context, posts []PostViews) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.BlogID)
+	}
+
+	cursor, err := getCollection("blogs").Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"blog_subject": 1}))
+	if err != nil {
+		return err
+	}
+	var blogs []models.Blog
+	if err := cursor.All(ctx, &blogs); err != nil {
+		return err
+	}
+
+	subjects := make(map[primitive.ObjectID]string, len(blogs))
+	for _, blog := range blogs {
+		subjects[blog.ID] = blog.Subject
+	}
+	for i := range posts {
+		posts[i].Subject = subjects[posts[i].BlogID]
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordAudit adds an entry to the audit log. A failure is logged rather
+// than undoing the action it records.
+func recordAudit(ctx context.Context, entry models.AuditEntry) {
+	entry.CreatedAt = time.Now()
+	if _, err := getCollection("audit_log").InsertOne(ctx, entry); err != nil {
+		log.Printf("Error recording audit entry %s for %s %s: %v", entry.Action, entry.TargetType, entry.TargetID, err)
+	}
+}
+
+// AuditFilter narrows the audit log. Empty fields match everything.
+type AuditFilter struct {
+	Action   string
+	ActorID  string
+	TargetID string
+}
+
+// GetAuditLog lists audit entries, newest first.
+func GetAuditLog(filter AuditFilter, limit, skip int64) ([]models.AuditEntry, error) {
+	collection, ctx, cancel := GetCollectionAndContext("audit_log")
+	defer cancel()
+
+	query := bson.M{}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.ActorID != "" {
+		query["actor_id"] = filter.ActorID
+	}
+	if filter.TargetID != "" {
+		query["target_id"] = filter.TargetID
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -59,9 +59,9 @@ func GetUser(userName, userPassword string) (*models.User, error) {
 	defer cancel()
 
 	var user models.User
-	err := collection.FindOne(ctx, bson.M{
+	err := collection.FindOne(ctx, notDeleted(bson.M{
 		"user_name": userName,
-	}).Decode(&user)
+	})).Decode(&user)
 
 	if err == mongo.ErrNoDocuments {
 		return nil, nil // User not found
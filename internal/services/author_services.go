@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuthorStats sums up an author's published posts.
+type AuthorStats struct {
+	PostCount      int64 `json:"post_count" bson:"post_count"`
+	TotalReactions int64 `json:"total_reactions" bson:"total_reactions"`
+	TotalComments  int64 `json:"total_comments" bson:"total_comments"`
+	TotalViews     int64 `json:"total_views" bson:"total_views"`
+}
+
+// AuthorPage is an author's public profile with a page of their posts,
+// newest first.
+type AuthorPage struct {
+	Author models.PublicUser `json:"author"`
+	Stats  AuthorStats       `json:"stats"`
+	Posts  []models.Blog     `json:"posts"`
+}
+
+// GetAuthorPage returns the public profile and posts of the named user.
+func GetAuthorPage(userName string, limit, skip int64) (*AuthorPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := findUserByName(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	filter := notDeleted(blogsByAuthor(user.ID.Hex(), user.UserName))
+	collection := getCollection("blogs")
+
+	page := &AuthorPage{Author: user.Public(), Posts: []models.Blog{}}
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &page.Posts); err != nil {
+		return nil, err
+	}
+
+	cursor, err = collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        nil,
+			"post_count": bson.M{"$sum": 1},
+			"total_reactions": bson.M{"$sum": bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}},
+				"in":    "$$this.v",
+			}}}},
+			"total_comments": bson.M{"$sum": "$comment_count"},
+			"total_views":    bson.M{"$sum": "$view_count"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var stats []AuthorStats
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	if len(stats) > 0 {
+		page.Stats = stats[0]
+	}
+	return page, nil
+}
+
+// AuthorLinkResult reports what LinkBlogAuthors did.
+type AuthorLinkResult struct {
+	Linked int64 `json:"linked"`
+	// Unmatched lists owner emails that no single account has.
+	Unmatched []string `json:"unmatched"`
+}
+
+// LinkBlogAuthors links posts from before posts referenced their author to
+// the account whose email matches blog_owner_email. Running it again only
+// picks up posts that are still unlinked.
+func LinkBlogAuthors() (*AuthorLinkResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	blogs := getCollection("blogs")
+	emails, err := blogs.Distinct(ctx, "blog_owner_email", bson.M{"author_id": nil})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AuthorLinkResult{Unmatched: []string{}}
+	for _, value := range emails {
+		email, ok := value.(string)
+		if !ok || email == "" {
+			continue
+		}
+
+		cursor, err := getCollection("users").Find(ctx, usersWithEmail(email),
+			options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(2))
+		if err != nil {
+			return nil, err
+		}
+		var users []models.User
+		if err := cursor.All(ctx, &users); err != nil {
+			return nil, err
+		}
+		if len(users) != 1 {
+			result.Unmatched = append(result.Unmatched, email)
+			continue
+		}
+
+		res, err := blogs.UpdateMany(ctx,
+			bson.M{"author_id": nil, "blog_owner_email": email},
+			bson.M{"$set": bson.M{"author_id": users[0].ID}})
+		if err != nil {
+			return nil, err
+		}
+		result.Linked += res.ModifiedCount
+	}
+
+	log.Printf("Linked %d posts to their authors; %d owner emails unmatched", result.Linked, len(result.Unmatched))
+	return result, nil
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"goserver/internal/models"
 
@@ -10,11 +12,16 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// blogManagedFields are maintained by the server and never taken from client
+// input when a blog is updated. createdAt keeps the original publish date,
+// and the byline stays with the author however many people edit the post.
+var blogManagedFields = []string{"createdAt", "deletedAt", "deletedBy", "reactions", "view_count", "comment_count", "mentions", "author_id", "blog_owner_name", "blog_owner_email"}
+
 func GetAllBlogs() ([]models.Blog, error) {
 	collection, ctx, cancel := GetCollectionAndContext("blogs")
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	cursor, err := collection.Find(ctx, notDeleted(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +54,7 @@ func GetBlogByID(id string) (*models.Blog, error) {
 	}
 
 	var blog models.Blog
-	err = collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&blog)
+	err = collection.FindOne(ctx, notDeleted(bson.M{"_id": objID})).Decode(&blog)
 	if err != nil {
 		return nil, nil // Not found or decode error
 	}
@@ -64,28 +71,66 @@ func SaveBlog(data *models.Blog) (string, error) {
 		blogID := data.ID
 		updateData := *data
 		updateData.ID = primitive.NilObjectID // Don't update the ID field
+		updateData.UpdatedAt = time.Now()
+
+		raw, err := bson.Marshal(updateData)
+		if err != nil {
+			return "", err
+		}
+		var fields bson.M
+		if err := bson.Unmarshal(raw, &fields); err != nil {
+			return "", err
+		}
+		for _, field := range blogManagedFields {
+			delete(fields, field)
+		}
+		mentioned, err := resolveMentions(ctx, data.Body)
+		if err != nil {
+			return "", err
+		}
+		fields["mentions"] = mentioned
 
 		update := bson.M{
-			"$set": updateData,
+			"$set": fields,
 		}
 
-		result := collection.FindOneAndUpdate(ctx, bson.M{"_id": blogID}, update)
+		result := collection.FindOneAndUpdate(ctx, notDeleted(bson.M{"_id": blogID}), update)
 
 		var updatedBlog models.Blog
 		if err := result.Decode(&updatedBlog); err != nil {
 			return "", err
 		}
 		log.Printf("Saved Blog: %s", updatedBlog.Subject)
+		InvalidateSitemap()
+		go dispatchNotifications(mentionEvents(NotificationEvent{ActorName: updatedBlog.OwnerName, BlogID: blogID}, addedMentions(updatedBlog.Mentions, mentioned)))
 		return updatedBlog.ID.Hex(), nil
 	} else {
 		// Create new blog
 		log.Printf("Creating new blog post.")
 		data.ID = primitive.NewObjectID()
+		data.DeletedAt = nil
+		data.DeletedBy = ""
+		data.Reactions = nil
+		data.ViewCount = 0
+		data.CommentCount = 0
+		if data.CreatedAt.IsZero() {
+			data.CreatedAt = time.Now()
+		}
+		data.UpdatedAt = data.CreatedAt
+		mentioned, err := resolveMentions(ctx, data.Body)
+		if err != nil {
+			return "", err
+		}
+		data.Mentions = mentioned
 		res, err := collection.InsertOne(ctx, data)
 		if err != nil {
 			return "", err
 		}
 		log.Printf("Saved Blog: %s", data.Subject)
+		InvalidateSitemap()
+		go dispatchNotifications(mentionEvents(NotificationEvent{ActorName: data.OwnerName, BlogID: data.ID}, data.Mentions))
+		publishPost(data)
+		go notifySubscribers(*data)
 		if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
 			return oid.Hex(), nil
 		}
@@ -93,22 +138,27 @@ func SaveBlog(data *models.Blog) (string, error) {
 	}
 }
 
-// DeleteBlog deletes a blog by its ID
-func DeleteBlog(id string) error {
-	collection, ctx, cancel := GetCollectionAndContext("blogs")
-	defer cancel()
-
+// DeleteBlog moves a blog and its comments to the trash
+func DeleteBlog(id, deletedBy string) (*CascadeResult, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objID})
+	var result CascadeResult
+	err = withTransaction(func(ctx context.Context) error {
+		result = CascadeResult{}
+		if err := trashBlogs(ctx, bson.M{"_id": objID}, deletedBy, time.Now(), &result); err != nil {
+			return err
+		}
+		if result.Blogs == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
 	if err != nil {
-		return err
-	}
-	if result.DeletedCount == 0 {
-		return mongo.ErrNoDocuments
+		return nil, err
 	}
-	return nil
+	InvalidateSitemap()
+	return &result, nil
 }
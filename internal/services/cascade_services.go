@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CascadeResult reports how many documents a delete touched in each collection.
+type CascadeResult struct {
+	Blogs    int64 `json:"blogs"`
+	Comments int64 `json:"comments"`
+	Users    int64 `json:"users"`
+}
+
+// What DeleteUser does with the posts and comments the user wrote.
+const (
+	UserContentReassign  = "reassign"
+	UserContentAnonymize = "anonymize"
+	UserContentDelete    = "delete"
+)
+
+// AnonymousAuthorName replaces the author name on anonymized content.
+const AnonymousAuthorName = "[deleted user]"
+
+var (
+	ErrUnknownContentMode = errors.New("content must be one of reassign, anonymize or delete")
+	ErrReassignTarget     = errors.New("reassign_to must be the ID of another active user")
+	ErrContentHandedOver  = errors.New("the user's posts and comments were reassigned or anonymized when they were deleted and cannot be given back")
+)
+
+// DeleteUserOptions selects the cascade behavior for DeleteUser.
+type DeleteUserOptions struct {
+	Content    string
+	ReassignTo string
+}
+
+// trashBlogs moves every live blog matching filter to the trash along with
+// its comments. Comments share the blog's deletedAt so that restoring the
+// blog can bring back exactly the comments that went with it.
+func trashBlogs(ctx context.Context, filter bson.M, deletedBy string, at time.Time, result *CascadeResult) error {
+	blogs := getCollection("blogs")
+
+	cursor, err := blogs.Find(ctx, notDeleted(filter))
+	if err != nil {
+		return err
+	}
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var blog models.Blog
+		if err := cursor.Decode(&blog); err != nil {
+			cursor.Close(ctx)
+			return err
+		}
+		ids = append(ids, blog.ID)
+	}
+	cursor.Close(ctx)
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	trash := bson.M{"$set": bson.M{"deletedAt": at, "deletedBy": deletedBy}}
+
+	res, err := blogs.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, trash)
+	if err != nil {
+		return err
+	}
+	result.Blogs += res.ModifiedCount
+
+	res, err = getCollection("comments").UpdateMany(ctx, notDeleted(bson.M{"blog_id": bson.M{"$in": ids}}), trash)
+	if err != nil {
+		return err
+	}
+	result.Comments += res.ModifiedCount
+	return nil
+}
+
+// restoreBlogWithComments restores a trashed blog and the comments that were
+// trashed together with it.
+func restoreBlogWithComments(ctx context.Context, blogID primitive.ObjectID) error {
+	blogs := getCollection("blogs")
+
+	var blog models.Blog
+	err := blogs.FindOne(ctx, bson.M{"_id": blogID, "deletedAt": bson.M{"$ne": nil}}).Decode(&blog)
+	if err != nil {
+		return err
+	}
+
+	restore := bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}}
+	if _, err := blogs.UpdateOne(ctx, bson.M{"_id": blogID}, restore); err != nil {
+		return err
+	}
+	_, err = getCollection("comments").UpdateMany(ctx, bson.M{"blog_id": blogID, "deletedAt": blog.DeletedAt}, restore)
+	if err != nil {
+		return err
+	}
+	return refreshCommentCounts(ctx, blogID)
+}
+
+// commentsByAuthor matches the comments written by the user: those linked
+// to their account, and unlinked ones under their user name. Like
+// blogsByAuthor, it never goes by the free-text email field, which anyone
+// can fill in.
+func commentsByAuthor(user *models.User) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"commenter_id": user.ID.Hex()},
+		bson.M{"commenter_id": bson.M{"$exists": false}, "commenter_name": user.UserName},
+	}}
+}
+
+// handOverUserContent applies the chosen cascade mode to everything user wrote.
+func handOverUserContent(ctx context.Context, user *models.User, deletedBy string, opts DeleteUserOptions, at time.Time, result *CascadeResult) error {
+	blogFilter := blogsByAuthor(user.ID.Hex(), user.UserName)
+	commentFilter := commentsByAuthor(user)
+
+	var blogSet, commentSet bson.M
+	switch opts.Content {
+	case UserContentReassign:
+		targetID, err := primitive.ObjectIDFromHex(opts.ReassignTo)
+		if err != nil || targetID == user.ID {
+			return ErrReassignTarget
+		}
+		var target models.User
+		err = getCollection("users").FindOne(ctx, notDeleted(bson.M{"_id": targetID})).Decode(&target)
+		if err == mongo.ErrNoDocuments {
+			return ErrReassignTarget
+		}
+		if err != nil {
+			return err
+		}
+		blogSet = bson.M{"blog_owner_name": target.UserName, "blog_owner_email": target.UserEmail, "author_id": target.ID}
+		commentSet = bson.M{"commenter_name": target.UserName, "commenter_email": target.UserEmail}
+	case UserContentAnonymize:
+		blogSet = bson.M{"blog_owner_name": AnonymousAuthorName, "blog_owner_email": "", "author_id": nil}
+		commentSet = bson.M{"commenter_name": AnonymousAuthorName, "commenter_email": ""}
+	case UserContentDelete:
+		if err := trashBlogs(ctx, blogFilter, deletedBy, at, result); err != nil {
+			return err
+		}
+		comments := getCollection("comments")
+		blogIDs, err := comments.Distinct(ctx, "blog_id", notDeleted(commentFilter))
+		if err != nil {
+			return err
+		}
+		res, err := comments.UpdateMany(ctx, notDeleted(commentFilter),
+			bson.M{"$set": bson.M{"deletedAt": at, "deletedBy": deletedBy}})
+		if err != nil {
+			return err
+		}
+		result.Comments += res.ModifiedCount
+
+		ids := make([]primitive.ObjectID, 0, len(blogIDs))
+		for _, id := range blogIDs {
+			if objID, ok := id.(primitive.ObjectID); ok {
+				ids = append(ids, objID)
+			}
+		}
+		return refreshCommentCounts(ctx, ids...)
+	default:
+		return ErrUnknownContentMode
+	}
+
+	res, err := getCollection("blogs").UpdateMany(ctx, blogFilter, bson.M{"$set": blogSet})
+	if err != nil {
+		return err
+	}
+	result.Blogs += res.ModifiedCount
+
+	res, err = getCollection("comments").UpdateMany(ctx, commentFilter, bson.M{"$set": commentSet})
+	if err != nil {
+		return err
+	}
+	result.Comments += res.ModifiedCount
+	return nil
+}
@@ -1,67 +1,385 @@
 package services
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
 	"time"
 
 	"goserver/internal/models"
+	"goserver/internal/realtime"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func GetCommentsByBlogID(blogID string) ([]models.Comment, error) {
+var (
+	ErrBlogNotFound        = errors.New("blog not found")
+	ErrParentNotFound      = errors.New("parent comment not found on this blog")
+	ErrMaxDepth            = errors.New("replies are nested too deeply")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrUnknownCommentSort  = errors.New("sort must be one of oldest, newest or reactions")
+	ErrEmptyComment        = errors.New("comment_body must not be empty")
+	ErrCommentForbidden    = errors.New("you may only change your own comments")
+	ErrEditWindowClosed    = errors.New("the time to change this comment has passed")
+	ErrCommentEditConflict = errors.New("the comment was changed by someone else; reload and try again")
+)
+
+// commentEditHistoryLimit is how many earlier versions of an edited
+// comment are kept.
+const commentEditHistoryLimit = 10
+
+// AddCommentOptions carries the limits and moderation rules that apply
+// when adding a comment.
+type AddCommentOptions struct {
+	// MaxDepth is the deepest a reply may be nested; top-level comments have depth 0.
+	MaxDepth int
+	// UserID, UserName and Role identify the authenticated commenter.
+	// Admins, and Creators on their own posts, are never held for review.
+	UserID   string
+	UserName string
+	Role     string
+	// TrustedThreshold is how many approved comments a commenter needs
+	// before new ones are published without review. Zero disables holding.
+	TrustedThreshold int64
+	// Comments whose spam score reaches SpamRejectThreshold are stored as
+	// spam; those reaching SpamHoldThreshold are held for review. Zero
+	// disables either threshold.
+	SpamHoldThreshold   float64
+	SpamRejectThreshold float64
+	// Honeypot, Elapsed and FormTimed are the spam signals taken from the
+	// submitted form; see SpamInput.
+	Honeypot  string
+	Elapsed   time.Duration
+	FormTimed bool
+}
+
+// CommentNode is a comment together with its replies.
+type CommentNode struct {
+	models.Comment
+	Replies []*CommentNode `json:"replies"`
+}
+
+// visibleComments restricts a filter to comments readers may see.
+func visibleComments(filter bson.M) bson.M {
+	filter["status"] = bson.M{"$in": bson.A{models.CommentStatusApproved, nil}}
+	return notDeleted(filter)
+}
+
+// Orders GetCommentsByBlogID can return top-level comments in.
+const (
+	CommentSortOldest    = "oldest"
+	CommentSortNewest    = "newest"
+	CommentSortReactions = "reactions"
+)
+
+// CommentPageOptions selects a page of a blog's comments. Cursor is the
+// NextCursor of the previous page. With Since set, only comments created
+// after it are returned, replies included, oldest first and Sort is ignored.
+type CommentPageOptions struct {
+	Sort   string
+	Limit  int64
+	Cursor string
+	Since  *time.Time
+}
+
+// publicCommentProjection leaves out the fields only moderators see.
+var publicCommentProjection = bson.M{"edit_history": 0, "spam_score": 0, "spam_reasons": 0}
+
+// commentCursor is the position after the last comment of a page.
+type commentCursor struct {
+	ID        primitive.ObjectID `json:"id"`
+	Reactions int64              `json:"r,omitempty"`
+}
+
+func (c commentCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCommentCursor(value string) (*commentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor commentCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// GetCommentsByBlogID returns one page of a blog's published comments and
+// the cursor of the next page, which is empty on the last page. Pages hold
+// up to opts.Limit top-level comments in the chosen order, each followed by
+// all of its replies in thread order.
+func GetCommentsByBlogID(blogID string, opts CommentPageOptions) ([]models.Comment, string, error) {
 	collection, ctx, cancel := GetCollectionAndContext("comments")
 	defer cancel()
 
 	objID, err := primitive.ObjectIDFromHex(blogID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	filter := bson.M{"blog_id": objID}
+	var after *commentCursor
+	if opts.Cursor != "" {
+		if after, err = decodeCommentCursor(opts.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
 
-	cursor, err := collection.Find(ctx, filter)
+	filter := visibleComments(bson.M{"blog_id": objID})
+	var sort bson.D
+	switch {
+	case opts.Since != nil:
+		filter["createdAt"] = bson.M{"$gt": *opts.Since}
+		sort = bson.D{{Key: "_id", Value: 1}}
+		if after != nil {
+			filter["_id"] = bson.M{"$gt": after.ID}
+		}
+	case opts.Sort == CommentSortOldest || opts.Sort == "":
+		filter["parent_id"] = nil
+		sort = bson.D{{Key: "_id", Value: 1}}
+		if after != nil {
+			filter["_id"] = bson.M{"$gt": after.ID}
+		}
+	case opts.Sort == CommentSortNewest:
+		filter["parent_id"] = nil
+		sort = bson.D{{Key: "_id", Value: -1}}
+		if after != nil {
+			filter["_id"] = bson.M{"$lt": after.ID}
+		}
+	case opts.Sort == CommentSortReactions:
+		filter["parent_id"] = nil
+		sort = bson.D{{Key: "reaction_total", Value: -1}, {Key: "_id", Value: -1}}
+		if after != nil {
+			filter["$or"] = bson.A{
+				bson.M{"reaction_total": bson.M{"$lt": after.Reactions}},
+				bson.M{"reaction_total": after.Reactions, "_id": bson.M{"$lt": after.ID}},
+			}
+		}
+	default:
+		return nil, "", ErrUnknownCommentSort
+	}
+
+	// Fetch one extra comment to learn whether there is another page.
+	findOpts := options.Find().SetSort(sort).SetLimit(opts.Limit + 1).SetProjection(publicCommentProjection)
+	cursor, err := collection.Find(ctx, filter, findOpts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	page := []models.Comment{}
+	if err := cursor.All(ctx, &page); err != nil {
+		return nil, "", err
 	}
-	defer cursor.Close(ctx)
 
-	var comments []models.Comment
-	for cursor.Next(ctx) {
-		var comment models.Comment
-		if err := cursor.Decode(&comment); err != nil {
-			return nil, err
-		}
-		comments = append(comments, comment)
+	next := ""
+	if int64(len(page)) > opts.Limit {
+		page = page[:opts.Limit]
+		last := page[len(page)-1]
+		next = commentCursor{ID: last.ID, Reactions: last.ReactionTotal}.encode()
+	}
+	if opts.Since != nil || len(page) == 0 {
+		return page, next, nil
+	}
+
+	comments, err := withReplies(ctx, collection, objID, page)
+	if err != nil {
+		return nil, "", err
+	}
+	return comments, next, nil
+}
+
+// withReplies follows each top-level comment with its published replies.
+func withReplies(ctx context.Context, collection *mongo.Collection, blogID primitive.ObjectID, roots []models.Comment) ([]models.Comment, error) {
+	rootIDs := make([]primitive.ObjectID, 0, len(roots))
+	for _, root := range roots {
+		rootIDs = append(rootIDs, root.ID)
+	}
+
+	cursor, err := collection.Find(ctx,
+		visibleComments(bson.M{"blog_id": blogID, "ancestors.0": bson.M{"$in": rootIDs}}),
+		options.Find().SetSort(bson.D{{Key: "thread_path", Value: 1}}).SetProjection(publicCommentProjection))
+	if err != nil {
+		return nil, err
 	}
-	if err := cursor.Err(); err != nil {
+	var replies []models.Comment
+	if err := cursor.All(ctx, &replies); err != nil {
 		return nil, err
 	}
+
+	byRoot := map[primitive.ObjectID][]models.Comment{}
+	for _, reply := range replies {
+		byRoot[reply.Ancestors[0]] = append(byRoot[reply.Ancestors[0]], reply)
+	}
+	comments := make([]models.Comment, 0, len(roots)+len(replies))
+	for _, root := range roots {
+		comments = append(comments, root)
+		comments = append(comments, byRoot[root.ID]...)
+	}
 	return comments, nil
 }
 
-// AddComment adds a new comment to the comments collection
-func AddComment(comment *models.Comment) (primitive.ObjectID, error) {
+// BuildCommentTree nests comments under their parents. comments must be in
+// thread order. Replies whose parent is missing are returned at the top level.
+func BuildCommentTree(comments []models.Comment) []*CommentNode {
+	roots := []*CommentNode{}
+	nodes := make(map[primitive.ObjectID]*CommentNode, len(comments))
+	for _, comment := range comments {
+		node := &CommentNode{Comment: comment, Replies: []*CommentNode{}}
+		nodes[comment.ID] = node
+
+		if comment.ParentID != nil {
+			if parent, ok := nodes[*comment.ParentID]; ok {
+				parent.Replies = append(parent.Replies, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// AddComment adds a new comment to the comments collection. A comment with
+// a ParentID is added as a reply to that comment. Comments from commenters
+// who are not yet trusted are held for review and the post's author is
+// notified; comment.Status reports the outcome.
+func AddComment(comment *models.Comment, opts AddCommentOptions) (primitive.ObjectID, error) {
 	collection, ctx, cancel := GetCollectionAndContext("comments")
 	defer cancel()
 
+	var blog models.Blog
+	err := getCollection("blogs").FindOne(ctx, notDeleted(bson.M{"_id": comment.BlogID})).Decode(&blog)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, ErrBlogNotFound
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
 	// Set the comment ID and CreatedAt
 	comment.ID = primitive.NewObjectID()
 	comment.CreatedAt = time.Now()
+	comment.Reactions = nil
+	comment.ReactionTotal = 0
+	comment.Edited = false
+	comment.EditedAt = nil
+	comment.EditHistory = nil
+	comment.Placeholder = false
+	comment.DeletedAt = nil
+	comment.DeletedBy = ""
+	comment.ModeratedBy = ""
+	comment.ModeratedAt = nil
+	comment.SpamScore = 0
+	comment.SpamReasons = nil
+
+	if err := placeInThread(ctx, collection, comment, opts.MaxDepth); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	comment.Status, err = initialCommentStatus(ctx, collection, comment, &blog, opts)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if !exemptFromModeration(&blog, opts) {
+		applySpamVerdict(comment, CheckSpam(ctx, &SpamInput{Comment: comment, Honeypot: opts.Honeypot, Elapsed: opts.Elapsed, FormTimed: opts.FormTimed}), opts)
+	}
+	if comment.Mentions, err = resolveMentions(ctx, comment.CommentBody); err != nil {
+		return primitive.NilObjectID, err
+	}
 
 	res, err := collection.InsertOne(ctx, comment)
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
+	// The comment is saved, so a failed recount must not make the client
+	// retry and post it twice; the next recount will fix the count
+	if err := refreshCommentCounts(ctx, comment.BlogID); err != nil {
+		log.Printf("Error refreshing comment count of blog %s: %v", comment.BlogID.Hex(), err)
+	}
+	switch comment.Status {
+	case models.CommentStatusPending:
+		go notifyCommentPending(*comment, blog)
+	case models.CommentStatusApproved:
+		go notifyCommentPublished(*comment)
+		publishComment(realtime.EventCommentCreated, *comment)
+	}
 	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
 		return oid, nil
 	}
 	return primitive.NilObjectID, nil
 }
 
-// UpdateComment updates a comment by its ID and blog ID
-func UpdateComment(blogID, commentID string, updateData map[string]interface{}) error {
+// placeInThread fills in the thread fields of a new comment from its parent.
+func placeInThread(ctx context.Context, collection *mongo.Collection, comment *models.Comment, maxDepth int) error {
+	if comment.ParentID == nil {
+		comment.Ancestors = nil
+		comment.Depth = 0
+		comment.ThreadPath = comment.ID.Hex()
+		return nil
+	}
+
+	var parent models.Comment
+	err := collection.FindOne(ctx, visibleComments(bson.M{"_id": *comment.ParentID, "blog_id": comment.BlogID})).Decode(&parent)
+	if err == mongo.ErrNoDocuments {
+		return ErrParentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if parent.Depth+1 > maxDepth {
+		return ErrMaxDepth
+	}
+
+	comment.Ancestors = append(append([]primitive.ObjectID{}, parent.Ancestors...), parent.ID)
+	comment.Depth = parent.Depth + 1
+	comment.ThreadPath = parent.ThreadPath + "." + comment.ID.Hex()
+	return nil
+}
+
+// CommentUpdate holds the fields of a comment its author may change.
+type CommentUpdate struct {
+	CommentBody string `json:"comment_body"`
+}
+
+// canChangeComment reports whether actor may edit or delete comment. Admins
+// and Creators always may; the comment's author only within editWindow of
+// posting, or at any time when editWindow is zero.
+func canChangeComment(comment *models.Comment, actor CommentActor, editWindow time.Duration) error {
+	if actor.isAdmin() || actor.Role == models.USER_ROLES["CREATOR"].Name {
+		return nil
+	}
+	if comment.CommenterID == "" || comment.CommenterID != actor.UserID {
+		return ErrCommentForbidden
+	}
+	if editWindow > 0 && time.Since(comment.CreatedAt) > editWindow {
+		return ErrEditWindowClosed
+	}
+	return nil
+}
+
+// CommentEditOptions configures UpdateComment.
+type CommentEditOptions struct {
+	// EditWindow is how long authors may edit their own comments; see
+	// canChangeComment.
+	EditWindow time.Duration
+	// An author's edit is scored for spam again and held or rejected as a
+	// new comment would be; see AddCommentOptions.
+	SpamHoldThreshold   float64
+	SpamRejectThreshold float64
+}
+
+// UpdateComment replaces the body of a comment, keeping the previous body
+// in the comment's edit history. An edit by the comment's author goes
+// through the spam checks again, so a published comment can't be edited
+// into spam afterwards.
+func UpdateComment(blogID, commentID string, update CommentUpdate, actor CommentActor, opts CommentEditOptions) error {
 	collection, ctx, cancel := GetCollectionAndContext("comments")
 	defer cancel()
 
@@ -73,29 +391,161 @@ func UpdateComment(blogID, commentID string, updateData map[string]interface{})
 	if err != nil {
 		return err
 	}
+	if strings.TrimSpace(update.CommentBody) == "" {
+		return ErrEmptyComment
+	}
 
-	// Remove fields that should not be updated
-	delete(updateData, "_id")
-	delete(updateData, "blog_id")
+	filter := notDeleted(bson.M{"_id": commentObjID, "blog_id": blogObjID, "placeholder": bson.M{"$ne": true}})
+	var comment models.Comment
+	if err := collection.FindOne(ctx, filter).Decode(&comment); err != nil {
+		return err
+	}
+	if err := canChangeComment(&comment, actor, opts.EditWindow); err != nil {
+		return err
+	}
+	if update.CommentBody == comment.CommentBody {
+		return nil
+	}
+	mentioned, err := resolveMentions(ctx, update.CommentBody)
+	if err != nil {
+		return err
+	}
 
-	filter := bson.M{"_id": commentObjID, "blog_id": blogObjID}
-	update := bson.M{"$set": updateData}
+	edited := comment
+	edited.CommentBody = update.CommentBody
+	var blog models.Blog
+	if err := getCollection("blogs").FindOne(ctx, bson.M{"_id": comment.BlogID}).Decode(&blog); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	spamOpts := AddCommentOptions{
+		UserID:              actor.UserID,
+		UserName:            actor.UserName,
+		Role:                actor.Role,
+		SpamHoldThreshold:   opts.SpamHoldThreshold,
+		SpamRejectThreshold: opts.SpamRejectThreshold,
+	}
+	if actor.UserID == comment.CommenterID && comment.Status != models.CommentStatusSpam && !exemptFromModeration(&blog, spamOpts) {
+		applySpamVerdict(&edited, CheckSpam(ctx, &SpamInput{Comment: &edited, Edit: true}), spamOpts)
+	}
 
-	result, err := collection.UpdateOne(ctx, filter, update)
+	now := time.Now()
+	previous := models.CommentEdit{CommentBody: comment.CommentBody, EditedAt: now, EditedBy: actor.UserID}
+	// Only apply the edit if nobody else changed the body since it was read,
+	// so the history never loses a version.
+	filter["comment_body"] = comment.CommentBody
+	result, err := collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"comment_body": update.CommentBody,
+			"mentions":     mentioned,
+			"status":       edited.Status,
+			"spam_score":   edited.SpamScore,
+			"spam_reasons": edited.SpamReasons,
+			"edited":       true,
+			"editedAt":     now,
+			"updatedAt":    now,
+		},
+		"$push": bson.M{"edit_history": bson.M{
+			"$each":  bson.A{previous},
+			"$slice": -commentEditHistoryLimit,
+		}},
+	})
 	if err != nil {
 		return err
 	}
 	if result.MatchedCount == 0 {
-		return mongo.ErrNoDocuments
+		return ErrCommentEditConflict
+	}
+
+	edited.Mentions = mentioned
+	edited.Edited = true
+	edited.EditedAt = &now
+	edited.UpdatedAt = now
+	switch {
+	case isPublished(&edited):
+		go dispatchNotifications(mentionEvents(commentEvent(&comment), addedMentions(comment.Mentions, mentioned)))
+		publishComment(realtime.EventCommentUpdated, edited)
+	case isPublished(&comment):
+		// The edit got the comment held or rejected
+		publishCommentDeleted(&comment)
+		if err := refreshCommentCounts(ctx, comment.BlogID); err != nil {
+			log.Printf("Error refreshing comment count of blog %s: %v", comment.BlogID.Hex(), err)
+		}
+		if edited.Status == models.CommentStatusPending {
+			go notifyCommentPending(edited, blog)
+		}
 	}
 	return nil
 }
 
-// DeleteComment deletes a comment by its ID and blog ID
-func DeleteComment(blogID, commentID string) error {
-	collection, ctx, cancel := GetCollectionAndContext("comments")
+// commentEvent returns a notification event with comment's author as the
+// actor; callers fill in the type and recipient.
+func commentEvent(comment *models.Comment) NotificationEvent {
+	id := comment.ID
+	return NotificationEvent{
+		ActorID:   comment.CommenterID,
+		ActorName: comment.CommenterName,
+		BlogID:    comment.BlogID,
+		CommentID: &id,
+	}
+}
+
+// notifyCommentPublished tells the author of the parent comment about a
+// reply, the users mentioned in the comment and the post's author about
+// a newly published comment. It is meant to run in its own goroutine.
+func notifyCommentPublished(comment models.Comment) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	var events []NotificationEvent
+	if comment.ParentID != nil {
+		var parent models.Comment
+		err := getCollection("comments").FindOne(ctx, bson.M{"_id": *comment.ParentID}).Decode(&parent)
+		if err == nil {
+			if recipient, err := primitive.ObjectIDFromHex(parent.CommenterID); err == nil {
+				event := commentEvent(&comment)
+				event.Type = models.NotificationCommentReply
+				event.Recipient = recipient
+				events = append(events, event)
+			}
+		}
+	}
+	events = append(events, mentionEvents(commentEvent(&comment), comment.Mentions)...)
+
+	var blog models.Blog
+	if err := getCollection("blogs").FindOne(ctx, bson.M{"_id": comment.BlogID}).Decode(&blog); err == nil {
+		owner, err := blogAuthor(ctx, &blog)
+		if err == nil && owner != nil {
+			event := commentEvent(&comment)
+			event.Type = models.NotificationCommentOnPost
+			event.Recipient = owner.ID
+			events = append(events, event)
+		}
+	}
+
+	dispatchNotifications(events)
+}
+
+// notifyCommentPending tells a post's author that a comment awaits review.
+// It is meant to run in its own goroutine.
+func notifyCommentPending(comment models.Comment, blog models.Blog) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	owner, err := blogAuthor(ctx, &blog)
+	if err != nil || owner == nil {
+		return
+	}
+	event := commentEvent(&comment)
+	event.Type = models.NotificationCommentPending
+	event.Recipient = owner.ID
+	dispatchNotifications([]NotificationEvent{event})
+}
+
+// DeleteComment deletes a comment by its ID and blog ID. A comment without
+// replies goes to the trash; one with replies is blanked out and kept as a
+// "[deleted]" placeholder so the thread still makes sense. Who may delete
+// follows the same rules as editing.
+func DeleteComment(blogID, commentID string, actor CommentActor, editWindow time.Duration) error {
 	blogObjID, err := primitive.ObjectIDFromHex(blogID)
 	if err != nil {
 		return err
@@ -105,13 +555,157 @@ func DeleteComment(blogID, commentID string) error {
 		return err
 	}
 
-	filter := bson.M{"_id": commentObjID, "blog_id": blogObjID}
-	result, err := collection.DeleteOne(ctx, filter)
+	var comment models.Comment
+	var placeholder bool
+	err = withTransaction(func(ctx context.Context) error {
+		collection := getCollection("comments")
+
+		comment = models.Comment{}
+		placeholder = false
+		err := collection.FindOne(ctx, notDeleted(bson.M{"_id": commentObjID, "blog_id": blogObjID})).Decode(&comment)
+		if err != nil {
+			return err
+		}
+		if err := canChangeComment(&comment, actor, editWindow); err != nil {
+			return err
+		}
+
+		replies, err := collection.CountDocuments(ctx, notDeleted(bson.M{"parent_id": comment.ID}))
+		if err != nil {
+			return err
+		}
+		if replies > 0 {
+			placeholder = true
+			_, err := collection.UpdateOne(ctx, bson.M{"_id": comment.ID}, bson.M{
+				"$set": bson.M{
+					"placeholder":     true,
+					"commenter_name":  models.DeletedCommentText,
+					"commenter_email": "",
+					"comment_body":    models.DeletedCommentText,
+					"updatedAt":       time.Now(),
+				},
+				"$unset": bson.M{"edit_history": ""},
+			})
+			if err != nil {
+				return err
+			}
+		} else if err := trashCommentAndEmptyParents(ctx, collection, &comment, actor.UserID); err != nil {
+			return err
+		}
+		return refreshCommentCounts(ctx, comment.BlogID)
+	})
 	if err != nil {
 		return err
 	}
-	if result.DeletedCount == 0 {
-		return mongo.ErrNoDocuments
+
+	if placeholder {
+		comment.Placeholder = true
+		comment.CommenterName = models.DeletedCommentText
+		comment.CommenterEmail = ""
+		comment.CommentBody = models.DeletedCommentText
+		comment.UpdatedAt = time.Now()
+		publishComment(realtime.EventCommentUpdated, comment)
+	} else if isPublished(&comment) {
+		publishCommentDeleted(&comment)
+	}
+	return nil
+}
+
+// trashCommentAndEmptyParents trashes a comment, then walks up the thread
+// trashing placeholder parents that no longer have any live replies.
+func trashCommentAndEmptyParents(ctx context.Context, collection *mongo.Collection, comment *models.Comment, deletedBy string) error {
+	now := time.Now()
+	trash := bson.M{"$set": bson.M{"deletedAt": now, "deletedBy": deletedBy}}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": comment.ID}, trash); err != nil {
+		return err
+	}
+
+	for i := len(comment.Ancestors) - 1; i >= 0; i-- {
+		parentID := comment.Ancestors[i]
+		replies, err := collection.CountDocuments(ctx, notDeleted(bson.M{"parent_id": parentID}))
+		if err != nil {
+			return err
+		}
+		if replies > 0 {
+			return nil
+		}
+		res, err := collection.UpdateOne(ctx, notDeleted(bson.M{"_id": parentID, "placeholder": true}), trash)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return nil
+		}
 	}
 	return nil
 }
+
+// refreshCommentCounts recounts the published comments of each blog and
+// stores the result in its comment_count. Placeholders are not counted.
+func refreshCommentCounts(ctx context.Context, blogIDs ...primitive.ObjectID) error {
+	done := map[primitive.ObjectID]bool{}
+	for _, blogID := range blogIDs {
+		if done[blogID] {
+			continue
+		}
+		done[blogID] = true
+
+		count, err := getCollection("comments").CountDocuments(ctx,
+			visibleComments(bson.M{"blog_id": blogID, "placeholder": bson.M{"$ne": true}}))
+		if err != nil {
+			return err
+		}
+		_, err = getCollection("blogs").UpdateOne(ctx, bson.M{"_id": blogID}, bson.M{"$set": bson.M{"comment_count": count}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateCommentCounts fills in the reaction totals of comments and the
+// comment counts of blogs stored before those fields existed.
+func MigrateCommentCounts() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	_, err := getCollection("comments").UpdateMany(ctx,
+		bson.M{"reaction_total": bson.M{"$exists": false}},
+		bson.A{bson.M{"$set": bson.M{"reaction_total": bson.M{"$sum": bson.M{"$map": bson.M{
+			"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$reactions", bson.M{}}}},
+			"in":    "$$this.v",
+		}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := getCollection("blogs").Find(ctx, bson.M{"comment_count": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var blogs []models.Blog
+	if err := cursor.All(ctx, &blogs); err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, 0, len(blogs))
+	for _, blog := range blogs {
+		ids = append(ids, blog.ID)
+	}
+	return refreshCommentCounts(ctx, ids...)
+}
+
+// MigrateCommentThreads gives comments created before threading a thread
+// path and depth so they sort as top-level comments.
+func MigrateCommentThreads() error {
+	collection, ctx, cancel := GetCollectionAndContext("comments")
+	defer cancel()
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"thread_path": bson.M{"$exists": false}},
+		bson.A{bson.M{"$set": bson.M{"thread_path": bson.M{"$toString": "$_id"}, "depth": 0}}},
+	)
+	return err
+}
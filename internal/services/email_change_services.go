@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"goserver/internal/models"
+	"goserver/internal/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmailInUse     = errors.New("email already in use")
+	ErrUserNameInUse  = errors.New("username already in use")
+	ErrEmailUnchanged = errors.New("that is already your email address")
+	ErrWrongPassword  = errors.New("current password is incorrect")
+)
+
+// tokenEmailChange is the purpose of the token in email change links.
+const tokenEmailChange = "email-change"
+
+// emailChangeTTL is how long an email change link works.
+const emailChangeTTL = 24 * time.Hour
+
+// RequestEmailChange starts changing a user's email address. The user must
+// give their current password. A confirmation link goes to the new address
+// and a notice to the old one; nothing changes until the link is followed.
+// A new request replaces any earlier one.
+func RequestEmailChange(userID, newEmail, password string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(newEmail))
+	if err != nil {
+		return ErrInvalidEmail
+	}
+	newEmail = normalizeEmail(addr.Address)
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return mongo.ErrNoDocuments
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.UserPassword), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	if strings.EqualFold(newEmail, user.UserEmail) {
+		return ErrEmailUnchanged
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	taken, err := emailTaken(ctx, newEmail, user.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailInUse
+	}
+
+	collection := getCollection("email_changes")
+	if _, err := collection.DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		return err
+	}
+	now := time.Now()
+	change := models.EmailChange{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		OldEmail:  user.UserEmail,
+		NewEmail:  newEmail,
+		ExpiresAt: now.Add(emailChangeTTL),
+		CreatedAt: now,
+	}
+	if _, err := collection.InsertOne(ctx, change); err != nil {
+		return err
+	}
+
+	token := tokens.Sign(linkSecret, tokenEmailChange, change.ID.Hex(), change.ExpiresAt)
+	confirmURL := frontendURL("/confirm-email?token=" + url.QueryEscape(token))
+	go SendEmailChangeConfirmation(change.ID.Hex(), newEmail, user.UserName, confirmURL)
+	if user.UserEmail != "" {
+		go SendEmailChangeNotice(change.ID.Hex(), user.UserEmail, user.UserName, newEmail)
+	}
+	return nil
+}
+
+// ConfirmEmailChange applies the email change a confirmation link was sent
+// for. The link stops working if the user's address changed in the
+// meantime or someone else has taken the new address.
+func ConfirmEmailChange(token string) (*models.User, error) {
+	id, err := tokens.Verify(linkSecret, tokenEmailChange, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	changeID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var change models.EmailChange
+	err = withTransaction(func(ctx context.Context) error {
+		err := getCollection("email_changes").FindOne(ctx, bson.M{
+			"_id":       changeID,
+			"expiresAt": bson.M{"$gt": time.Now()},
+		}).Decode(&change)
+		if err == mongo.ErrNoDocuments {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := setUserEmail(ctx, change.UserID, change.OldEmail, change.NewEmail); err != nil {
+			return err
+		}
+		_, err = getCollection("email_changes").DeleteOne(ctx, bson.M{"_id": changeID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	moveSubscriptions(ctx, change.UserID, change.NewEmail)
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditEmailChanged,
+		ActorID:    change.UserID.Hex(),
+		TargetType: "user",
+		TargetID:   change.UserID.Hex(),
+		Details:    map[string]string{"old_email": change.OldEmail, "new_email": change.NewEmail},
+	})
+	return GetUserByID(change.UserID.Hex())
+}
+
+// setUserEmail changes a user's address from oldEmail to newEmail, provided
+// it is still oldEmail and no one else has newEmail.
+func setUserEmail(ctx context.Context, userID primitive.ObjectID, oldEmail, newEmail string) error {
+	taken, err := emailTaken(ctx, newEmail, userID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailInUse
+	}
+	suppressed, err := IsEmailSuppressed(newEmail)
+	if err != nil {
+		return err
+	}
+
+	result, err := getCollection("users").UpdateOne(ctx,
+		notDeleted(bson.M{"_id": userID, "user_email": oldEmail}),
+		bson.M{"$set": bson.M{
+			"user_email":          newEmail,
+			"email_undeliverable": suppressed,
+			"updatedAt":           time.Now(),
+		}})
+	if emailConflict(err) {
+		return ErrEmailInUse
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// emailTaken reports whether a user other than userID has the address,
+// ignoring case. Trashed users count, so that they can be restored. The
+// unique index on user_email is the final word; this only lets callers
+// fail early with a clear error.
+func emailTaken(ctx context.Context, email string, userID primitive.ObjectID) (bool, error) {
+	filter := usersWithEmail(email)
+	filter["_id"] = bson.M{"$ne": userID}
+	count, err := getCollection("users").CountDocuments(ctx, filter,
+		options.Count().SetCollation(emailCollation).SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// moveSubscriptions sends a user's subscriptions to their new address. A
+// subscription the new address already has on its own is kept instead.
+func moveSubscriptions(ctx context.Context, userID primitive.ObjectID, email string) {
+	collection := getCollection("subscriptions")
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		log.Printf("Error moving subscriptions of user %s: %v", userID.Hex(), err)
+		return
+	}
+	var subs []models.Subscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		log.Printf("Error moving subscriptions of user %s: %v", userID.Hex(), err)
+		return
+	}
+
+	email = strings.ToLower(email)
+	for _, sub := range subs {
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": bson.M{"email": email, "updatedAt": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			_, err = collection.DeleteOne(ctx, bson.M{"_id": sub.ID})
+		}
+		if err != nil {
+			log.Printf("Error moving subscription %s: %v", sub.ID.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlogFilter narrows a blog listing to a single category and/or author.
+type BlogFilter struct {
+	Category string
+	Author   string
+}
+
+func (f BlogFilter) query() bson.M {
+	filter := notDeleted(bson.M{})
+	if f.Category != "" {
+		filter["blog_category"] = f.Category
+	}
+	if f.Author != "" {
+		filter["blog_owner_name"] = f.Author
+	}
+	return filter
+}
+
+// BlogCollectionState summarizes a filtered blog listing so callers can
+// answer conditional requests without loading the documents themselves.
+type BlogCollectionState struct {
+	Count        int64
+	LastModified time.Time
+}
+
+// GetBlogCollectionState returns the number of blogs matching the filter and
+// the most recent creation or update time among them.
+func GetBlogCollectionState(filter BlogFilter) (*BlogCollectionState, error) {
+	collection, ctx, cancel := GetCollectionAndContext("blogs")
+	defer cancel()
+
+	pipeline := bson.A{
+		bson.M{"$match": filter.query()},
+		bson.M{"$group": bson.M{
+			"_id":     nil,
+			"count":   bson.M{"$sum": 1},
+			"updated": bson.M{"$max": "$updatedAt"},
+			"created": bson.M{"$max": "$createdAt"},
+		}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	state := &BlogCollectionState{}
+	if cursor.Next(ctx) {
+		var result struct {
+			Count   int64     `bson:"count"`
+			Updated time.Time `bson:"updated"`
+			Created time.Time `bson:"created"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		state.Count = result.Count
+		state.LastModified = result.Updated
+		if result.Created.After(state.LastModified) {
+			state.LastModified = result.Created
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// GetRecentBlogs returns up to limit blogs matching the filter, most recently
+// created first.
+func GetRecentBlogs(filter BlogFilter, limit int64) ([]models.Blog, error) {
+	collection, ctx, cancel := GetCollectionAndContext("blogs")
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, filter.query(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var blogs []models.Blog
+	for cursor.Next(ctx) {
+		var blog models.Blog
+		if err := cursor.Decode(&blog); err != nil {
+			return nil, err
+		}
+		blogs = append(blogs, blog)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return blogs, nil
+}
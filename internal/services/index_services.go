@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes each collection needs.
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"users": {
+		{
+			// One account per address, whatever its case
+			Keys: bson.D{{Key: "user_email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(emailCollation).
+				SetPartialFilterExpression(bson.M{"user_email": bson.M{"$type": "string"}}),
+		},
+	},
+	"reactions": {
+		{
+			// One reaction of each type per user and target
+			Keys: bson.D{
+				{Key: "target_type", Value: 1},
+				{Key: "target_id", Value: 1},
+				{Key: "user_id", Value: 1},
+				{Key: "reaction_type", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "target_type", Value: 1},
+				{Key: "target_id", Value: 1},
+				{Key: "reaction_type", Value: 1},
+				{Key: "createdAt", Value: 1},
+			},
+		},
+	},
+	"blogs": {
+		{Keys: bson.D{{Key: "author_id", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "blog_owner_name", Value: 1}, {Key: "createdAt", Value: -1}}},
+	},
+	"comments": {
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "thread_path", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+		{Keys: bson.D{{Key: "ancestors", Value: 1}}},
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "reaction_total", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "createdAt", Value: 1}}},
+	},
+	"notifications": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "read", Value: 1}}},
+	},
+	"email_outbox": {
+		{
+			Keys: bson.D{{Key: "idempotency_key", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$type": "string"}}),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "locked_until", Value: 1}}},
+		{
+			// Delivered email is kept for a month for troubleshooting
+			Keys:    bson.D{{Key: "sentAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	},
+	"subscriptions": {
+		{
+			// One subscription per address and subject
+			Keys:    bson.D{{Key: "email", Value: 1}, {Key: "scope", Value: 1}, {Key: "target", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "confirmed", Value: 1}, {Key: "delivery", Value: 1}, {Key: "lastDigestAt", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"email_suppressions": {
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "updatedAt", Value: -1}}},
+	},
+	"email_changes": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{
+			// Unconfirmed changes are dropped once their link has expired
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	},
+	"invitations": {
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "invited_by", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+	},
+	"role_requests": {
+		{
+			// A user can have only one request waiting at a time
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": models.RoleRequestPending}),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}, {Key: "decidedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+	},
+	"audit_log": {
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+	},
+	"blog_views_daily": {
+		{
+			Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "day", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "day", Value: 1}}},
+	},
+	"blog_referrers_daily": {
+		{
+			Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "day", Value: 1}, {Key: "referrer", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "day", Value: 1}}},
+	},
+}
+
+// requiredIndexes are the collections whose unique indexes enforce rules no
+// code checks on its own, so the server must not run without them.
+var requiredIndexes = map[string]bool{
+	"users": true,
+}
+
+var ErrRequiredIndex = errors.New("an index the server can't run without could not be built")
+
+// EnsureIndexes creates the indexes the services rely on. Creating an index
+// that already exists is a no-op, so this is safe to call on every start.
+// A collection whose indexes can't be built, e.g. because existing data
+// breaks a unique index, doesn't stop the others. The error wraps
+// ErrRequiredIndex if one of requiredIndexes failed.
+func EnsureIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var errs []error
+	for name, indexes := range collectionIndexes {
+		if _, err := getCollection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			if requiredIndexes[name] {
+				err = fmt.Errorf("%w: %w", ErrRequiredIndex, err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
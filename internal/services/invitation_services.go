@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"goserver/internal/models"
+	"goserver/internal/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvitationRole       = errors.New("role must be an existing role no higher than your own")
+	ErrInvitationExpiry     = errors.New("expiry must be between 1 hour and 30 days")
+	ErrInvitationMessage    = errors.New("message must be at most 1000 characters")
+	ErrAlreadyInvited       = errors.New("that address already has a pending invitation")
+	ErrInviterRole          = errors.New("whoever sent this invitation can no longer grant its role")
+	ErrInvitationNotPending = errors.New("invitation has already been accepted or revoked")
+	ErrUserNameRequired     = errors.New("user_name is required")
+)
+
+// tokenInvitation is the purpose of the token in invitation links.
+const tokenInvitation = "invitation"
+
+// Limits on invitations.
+const (
+	minInvitationExpiry  = time.Hour
+	maxInvitationExpiry  = 30 * 24 * time.Hour
+	maxInvitationMessage = 1000
+)
+
+// InvitationRequest is what an inviter fills in.
+type InvitationRequest struct {
+	Email     string
+	Role      string
+	Message   string
+	ExpiresIn time.Duration
+}
+
+// roleByName returns the canonical name of a role, ignoring case, or "".
+func roleByName(name string) string {
+	for _, role := range models.USER_ROLES {
+		if strings.EqualFold(role.Name, name) {
+			return role.Name
+		}
+	}
+	return ""
+}
+
+// canGrantRole reports whether someone with the granter's role may give
+// out role by invitation. Only Admins invite, and never to a role above
+// their own.
+func canGrantRole(granter, role string) bool {
+	level := roleLevel(role)
+	return granter == models.USER_ROLES["ADMIN"].Name && level > 0 && level <= roleLevel(granter)
+}
+
+// CreateInvitation invites an address to join with a role, and emails it a
+// link to accept.
+func CreateInvitation(req InvitationRequest, inviter CommentActor) (*models.Invitation, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	role := roleByName(req.Role)
+	if !canGrantRole(inviter.Role, role) {
+		return nil, ErrInvitationRole
+	}
+	if req.ExpiresIn < minInvitationExpiry || req.ExpiresIn > maxInvitationExpiry {
+		return nil, ErrInvitationExpiry
+	}
+	message := strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(message) > maxInvitationMessage {
+		return nil, ErrInvitationMessage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	email := strings.ToLower(addr.Address)
+	taken, err := emailTaken(ctx, email, primitive.NilObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailInUse
+	}
+
+	collection := getCollection("invitations")
+	now := time.Now()
+	pending, err := collection.CountDocuments(ctx, bson.M{
+		"email":     email,
+		"status":    models.InvitationPending,
+		"expiresAt": bson.M{"$gt": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, ErrAlreadyInvited
+	}
+
+	invitation := models.Invitation{
+		ID:            primitive.NewObjectID(),
+		Email:         email,
+		Role:          role,
+		Message:       message,
+		InvitedBy:     inviter.UserID,
+		InvitedByName: inviter.UserName,
+		Status:        models.InvitationPending,
+		ExpiresAt:     now.Add(req.ExpiresIn),
+		SentAt:        now,
+		SendCount:     1,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if _, err := collection.InsertOne(ctx, invitation); err != nil {
+		return nil, err
+	}
+
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditInvitationCreated,
+		ActorID:    inviter.UserID,
+		ActorName:  inviter.UserName,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+		Details:    map[string]string{"email": email, "role": role},
+	})
+	go sendInvitation(invitation)
+	return &invitation, nil
+}
+
+func sendInvitation(invitation models.Invitation) {
+	token := tokens.Sign(linkSecret, tokenInvitation, invitation.ID.Hex(), invitation.ExpiresAt)
+	acceptURL := frontendURL("/accept-invitation?token=" + url.QueryEscape(token))
+	if err := SendInvitationEmail(&invitation, acceptURL); err != nil {
+		log.Printf("Error sending invitation %s: %v", invitation.ID.Hex(), err)
+	}
+}
+
+// withInvitationStatus reports pending invitations that have run out as
+// expired.
+func withInvitationStatus(invitation *models.Invitation, now time.Time) {
+	if invitation.Status == models.InvitationPending && !invitation.ExpiresAt.After(now) {
+		invitation.Status = models.InvitationExpired
+	}
+}
+
+// GetInvitations lists invitations, newest first.
+func GetInvitations(status string, limit, skip int64) ([]models.Invitation, error) {
+	collection, ctx, cancel := GetCollectionAndContext("invitations")
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{}
+	switch status {
+	case "":
+	case models.InvitationPending:
+		filter["status"] = models.InvitationPending
+		filter["expiresAt"] = bson.M{"$gt": now}
+	case models.InvitationExpired:
+		filter["status"] = models.InvitationPending
+		filter["expiresAt"] = bson.M{"$lte": now}
+	default:
+		filter["status"] = status
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	invitations := []models.Invitation{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		return nil, err
+	}
+	for i := range invitations {
+		withInvitationStatus(&invitations[i], now)
+	}
+	return invitations, nil
+}
+
+// managedInvitation loads an invitation by its ID.
+func managedInvitation(ctx context.Context, id string) (*models.Invitation, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var invitation models.Invitation
+	if err := getCollection("invitations").FindOne(ctx, bson.M{"_id": objID}).Decode(&invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// ResendInvitation emails a pending invitation again, valid for expiresIn
+// from now. An expired invitation can be resent too.
+func ResendInvitation(id string, actor CommentActor, expiresIn time.Duration) (*models.Invitation, error) {
+	if expiresIn < minInvitationExpiry || expiresIn > maxInvitationExpiry {
+		return nil, ErrInvitationExpiry
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invitation, err := managedInvitation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// The inviter's own role may have changed since
+	if !canGrantRole(actor.Role, invitation.Role) {
+		return nil, ErrInvitationRole
+	}
+
+	now := time.Now()
+	err = getCollection("invitations").FindOneAndUpdate(ctx,
+		bson.M{"_id": invitation.ID, "status": models.InvitationPending},
+		bson.M{
+			"$set": bson.M{"expiresAt": now.Add(expiresIn), "sentAt": now, "updatedAt": now},
+			"$inc": bson.M{"send_count": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(invitation)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvitationNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go sendInvitation(*invitation)
+	return invitation, nil
+}
+
+// RevokeInvitation stops a pending invitation from being accepted.
+func RevokeInvitation(id string, actor CommentActor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invitation, err := managedInvitation(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result, err := getCollection("invitations").UpdateOne(ctx,
+		bson.M{"_id": invitation.ID, "status": models.InvitationPending},
+		bson.M{"$set": bson.M{
+			"status":     models.InvitationRevoked,
+			"revokedAt":  now,
+			"revoked_by": actor.UserID,
+			"updatedAt":  now,
+		}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvitationNotPending
+	}
+
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditInvitationRevoked,
+		ActorID:    actor.UserID,
+		ActorName:  actor.UserName,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+		Details:    map[string]string{"email": invitation.Email, "role": invitation.Role},
+	})
+	return nil
+}
+
+// LookupInvitation returns the pending invitation an invitation link was
+// sent for, so the invitee can see what they are accepting.
+func LookupInvitation(token string) (*models.Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return pendingInvitation(ctx, token)
+}
+
+func pendingInvitation(ctx context.Context, token string) (*models.Invitation, error) {
+	id, err := tokens.Verify(linkSecret, tokenInvitation, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var invitation models.Invitation
+	err = getCollection("invitations").FindOne(ctx, bson.M{
+		"_id":       objID,
+		"status":    models.InvitationPending,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// AcceptInvitation creates the invitee's account with the invited role. The
+// account is verified already, since the invitation link proves the
+// address works.
+func AcceptInvitation(token, userName, password string) (*models.User, error) {
+	userName = strings.TrimSpace(userName)
+	if userName == "" {
+		return nil, ErrUserNameRequired
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invitation, err := pendingInvitation(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	// The inviter's own role may have changed since, or their account gone
+	inviter, err := GetUserByID(invitation.InvitedBy)
+	if err != nil {
+		return nil, err
+	}
+	if inviter == nil || !canGrantRole(inviter.Role, invitation.Role) {
+		return nil, ErrInviterRole
+	}
+
+	// Someone may have signed up with the address since it was invited
+	taken, err := emailTaken(ctx, invitation.Email, primitive.NilObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailInUse
+	}
+
+	// Claim the invitation first so that it can only be used once
+	collection := getCollection("invitations")
+	now := time.Now()
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": invitation.ID, "status": models.InvitationPending, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"status": models.InvitationAccepted, "acceptedAt": now, "updatedAt": now}})
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	user := models.User{
+		UserName:     userName,
+		UserEmail:    invitation.Email,
+		UserPassword: password,
+		Role:         invitation.Role,
+	}
+	if err := insertUser(&user, true); err != nil {
+		_, undoErr := collection.UpdateOne(ctx, bson.M{"_id": invitation.ID},
+			bson.M{"$set": bson.M{"status": models.InvitationPending, "updatedAt": time.Now()}, "$unset": bson.M{"acceptedAt": ""}})
+		if undoErr != nil {
+			log.Printf("Error reopening invitation %s: %v", invitation.ID.Hex(), undoErr)
+		}
+		return nil, err
+	}
+
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": invitation.ID}, bson.M{"$set": bson.M{"accepted_by": user.ID}}); err != nil {
+		log.Printf("Error recording who accepted invitation %s: %v", invitation.ID.Hex(), err)
+	}
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditInvitationAccepted,
+		ActorID:    user.ID.Hex(),
+		ActorName:  user.UserName,
+		TargetType: "invitation",
+		TargetID:   invitation.ID.Hex(),
+		Details:    map[string]string{"email": user.UserEmail, "role": user.Role, "invited_by": invitation.InvitedBy},
+	})
+	return &user, nil
+}
@@ -1,12 +1,22 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"goserver/internal/mailer"
+	"goserver/internal/models"
+	"goserver/internal/templates"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type EmailRequest struct {
@@ -14,44 +24,132 @@ type EmailRequest struct {
 	Subject string
 	Text    string
 	HTML    string
+	Headers map[string]string
+	// IdempotencyKey, when set, makes queueing the same email twice a no-op.
+	IdempotencyKey string
+	// Sensitive email carries a link that acts as a credential. Its body is
+	// never shown by the outbox API and is dropped once it has been sent.
+	Sensitive bool
 }
 
-// SendEmail sends an email using SendGrid
-func SendEmail(req EmailRequest) error {
-	from := mail.NewEmail("", os.Getenv("SENDGRID_FROM_EMAIL"))
-	to := mail.NewEmail("", req.To)
+var ErrMailerNotConfigured = errors.New("no mailer is configured")
 
-	// Use HTML if provided, otherwise fall back to text
-	content := req.HTML
-	if content == "" {
-		content = req.Text
-	}
+// sensitiveTemplates are the templates whose links sign someone in, reset a
+// password or otherwise stand in for a credential.
+var sensitiveTemplates = map[string]bool{
+	"password_reset":       true,
+	"verify_email":         true,
+	"email_change_confirm": true,
+	"invitation":           true,
+	"subscription_confirm": true,
+}
 
-	message := mail.NewSingleEmail(from, req.Subject, to, req.Text, content)
+// secretKey builds an idempotency key from a secret without putting the
+// secret itself in the outbox.
+func secretKey(prefix, secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return prefix + ":" + hex.EncodeToString(sum[:16])
+}
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
-	response, err := client.Send(message)
+var (
+	activeMailer   mailer.Mailer
+	mailFrom       string
+	emailTemplates = templates.New("", "en", nil)
+)
 
-	if err != nil {
-		log.Printf("Error sending email: %v", err)
+// SetMailer chooses how email is delivered and the address it is sent from.
+func SetMailer(m mailer.Mailer, from string) {
+	activeMailer = m
+	mailFrom = from
+}
+
+// SetEmailTemplates chooses the templates emails are rendered from.
+func SetEmailTemplates(r *templates.Renderer) {
+	emailTemplates = r
+}
+
+// SendEmail queues an email in the outbox. It is delivered in the
+// background, so a slow or failing provider never holds up the caller.
+func SendEmail(req EmailRequest) error {
+	if err := enqueueEmail(req); err != nil {
+		log.Printf("Error queueing email: %v", err)
 		return err
 	}
 
-	log.Printf("Email sent successfully: %d", response.StatusCode)
+	log.Printf("Email queued for %s", req.To)
 	return nil
 }
 
+// sendTemplatedEmail renders the named template in the recipient's
+// preferred language and queues it.
+func sendTemplatedEmail(to, name, idempotencyKey string, data templates.Data) error {
+	email, err := emailTemplates.Render(name, userLocale(to), data)
+	if err != nil {
+		return err
+	}
+	return SendEmail(EmailRequest{
+		To:             to,
+		Subject:        email.Subject,
+		Text:           email.Text,
+		HTML:           email.HTML,
+		IdempotencyKey: idempotencyKey,
+		Sensitive:      sensitiveTemplates[name],
+	})
+}
+
+// userLocale returns the preferred locale of the user with the given email
+// address, or "" to use the default.
+func userLocale(email string) string {
+	collection, ctx, cancel := GetCollectionAndContext("users")
+	defer cancel()
+
+	var user struct {
+		Locale string `bson:"locale"`
+	}
+	err := collection.FindOne(ctx, usersWithEmail(email),
+		options.FindOne().SetProjection(bson.M{"locale": 1})).Decode(&user)
+	if err != nil {
+		return ""
+	}
+	return user.Locale
+}
+
+// PreviewEmail renders a template with its sample data, overridden by data.
+func PreviewEmail(name, locale string, data templates.Data) (*templates.Email, error) {
+	merged := templates.Data{}
+	for key, value := range templates.Samples[name] {
+		merged[key] = value
+	}
+	for key, value := range data {
+		merged[key] = value
+	}
+	return emailTemplates.Render(name, locale, merged)
+}
+
+// EmailTemplate describes a template that can be previewed.
+type EmailTemplate struct {
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+}
+
+// ListEmailTemplates lists the email templates and their translations.
+func ListEmailTemplates() []EmailTemplate {
+	list := []EmailTemplate{}
+	for _, name := range emailTemplates.Names() {
+		list = append(list, EmailTemplate{Name: name, Locales: emailTemplates.Locales(name)})
+	}
+	return list
+}
+
+// frontendURL joins path onto the frontend's address.
+func frontendURL(path string) string {
+	return strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + path
+}
+
 // SendWelcomeEmail sends a welcome email to new users
-func SendWelcomeEmail(userEmail, userName string) error {
-	err := SendEmail(EmailRequest{
-		To:      userEmail,
-		Subject: "Welcome to Our Platform!",
-		Text:    fmt.Sprintf("Hello %s, welcome to our platform!", userName),
-		HTML: fmt.Sprintf(`
-            <h1>Welcome %s!</h1>
-            <p>Thank you for joining our platform.</p>
-            <p>Best regards,<br>The Team</p>
-        `, userName),
+func SendWelcomeEmail(userID, userEmail, userName string) error {
+	err := sendTemplatedEmail(userEmail, "welcome", "welcome:"+userID, templates.Data{
+		"UserName": userName,
 	})
 
 	if err != nil {
@@ -59,25 +157,14 @@ func SendWelcomeEmail(userEmail, userName string) error {
 		return err
 	}
 
-	log.Printf("Welcome email sent to %s", userEmail)
+	log.Printf("Welcome email queued for %s", userEmail)
 	return nil
 }
 
 // SendPasswordResetEmail sends a password reset email
 func SendPasswordResetEmail(userEmail, resetToken string) error {
-	resetURL := fmt.Sprintf("%s/reset-password?token=%s", os.Getenv("FRONTEND_URL"), resetToken)
-
-	err := SendEmail(EmailRequest{
-		To:      userEmail,
-		Subject: "Password Reset Request",
-		Text:    fmt.Sprintf("Please click the following link to reset your password: %s", resetURL),
-		HTML: fmt.Sprintf(`
-            <h2>Password Reset Request</h2>
-            <p>You requested a password reset. Click the link below to reset your password:</p>
-            <a href="%s" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px;">Reset Password</a>
-            <p>If you didn't request this, please ignore this email.</p>
-            <p>This link will expire in 1 hour.</p>
-        `, resetURL),
+	err := sendTemplatedEmail(userEmail, "password_reset", secretKey("password-reset", resetToken), templates.Data{
+		"ResetURL": frontendURL("/reset-password?token=" + url.QueryEscape(resetToken)),
 	})
 
 	if err != nil {
@@ -85,22 +172,48 @@ func SendPasswordResetEmail(userEmail, resetToken string) error {
 		return err
 	}
 
-	log.Printf("Password reset email sent to %s", userEmail)
+	log.Printf("Password reset email queued for %s", userEmail)
 	return nil
 }
 
-// SendBlogNotification sends a notification email about new blog posts
-func SendBlogNotification(userEmail, blogTitle, blogAuthor string) error {
-	err := SendEmail(EmailRequest{
-		To:      userEmail,
-		Subject: fmt.Sprintf("New Blog Post: %s", blogTitle),
-		Text:    fmt.Sprintf("A new blog post \"%s\" has been published by %s.", blogTitle, blogAuthor),
-		HTML: fmt.Sprintf(`
-            <h2>New Blog Post Published!</h2>
-            <h3>%s</h3>
-            <p>Author: %s</p>
-            <p>Check out the latest blog post on our platform.</p>
-        `, blogTitle, blogAuthor),
+// sendSubscriptionEmail sends a templated email about a subscription, with
+// an unsubscribe link in the body and one-click unsubscribe headers. The
+// unsubscribe link works without signing in, so the email is sensitive.
+func sendSubscriptionEmail(sub *models.Subscription, name, idempotencyKey string, data templates.Data) error {
+	unsubscribeURL, headers := subscriptionLinks(sub)
+	data["Scope"] = sub.Scope
+	data["Target"] = subscriptionTargetName(sub)
+	data["UnsubscribeURL"] = unsubscribeURL
+
+	email, err := emailTemplates.Render(name, userLocale(sub.Email), data)
+	if err != nil {
+		return err
+	}
+	return SendEmail(EmailRequest{
+		To:             sub.Email,
+		Subject:        email.Subject,
+		Text:           email.Text,
+		HTML:           email.HTML,
+		Headers:        headers,
+		IdempotencyKey: idempotencyKey,
+		Sensitive:      true,
+	})
+}
+
+// subscriptionTargetName is how emails name what sub follows.
+func subscriptionTargetName(sub *models.Subscription) string {
+	if sub.TargetName != "" {
+		return sub.TargetName
+	}
+	return sub.Target
+}
+
+// SendBlogNotification sends a subscriber a notification email about a new blog post
+func SendBlogNotification(sub *models.Subscription, blog *models.Blog) error {
+	err := sendSubscriptionEmail(sub, "blog_notification", "new-post:"+blog.ID.Hex()+":"+sub.Email, templates.Data{
+		"BlogTitle":  blog.Subject,
+		"BlogAuthor": blog.OwnerName,
+		"Link":       frontendURL("/blog/" + blog.ID.Hex()),
 	})
 
 	if err != nil {
@@ -108,25 +221,116 @@ func SendBlogNotification(userEmail, blogTitle, blogAuthor string) error {
 		return err
 	}
 
-	log.Printf("Blog notification sent to %s", userEmail)
+	log.Printf("Blog notification queued for %s", sub.Email)
+	return nil
+}
+
+// DigestPost is one post listed in a digest email.
+type DigestPost struct {
+	Title  string
+	Author string
+	Link   string
+}
+
+// SendDigestEmail sends a subscriber the posts published since their last digest
+func SendDigestEmail(sub *models.Subscription, blogs []models.Blog, since time.Time) error {
+	posts := make([]DigestPost, 0, len(blogs))
+	for _, blog := range blogs {
+		posts = append(posts, DigestPost{
+			Title:  blog.Subject,
+			Author: blog.OwnerName,
+			Link:   frontendURL("/blog/" + blog.ID.Hex()),
+		})
+	}
+	key := fmt.Sprintf("digest:%s:%d", sub.ID.Hex(), since.Unix())
+	err := sendSubscriptionEmail(sub, "digest", key, templates.Data{
+		"Delivery": sub.Delivery,
+		"Posts":    posts,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send digest email: %v", err)
+		return err
+	}
+
+	log.Printf("Digest email queued for %s", sub.Email)
+	return nil
+}
+
+// SendSubscriptionConfirmation asks someone who subscribed without signing in to confirm their address
+func SendSubscriptionConfirmation(sub *models.Subscription, confirmURL string) error {
+	key := fmt.Sprintf("subscription-confirm:%s:%s", sub.ID.Hex(), time.Now().Format("2006-01-02T15"))
+	err := sendTemplatedEmail(sub.Email, "subscription_confirm", key, templates.Data{
+		"Scope":      sub.Scope,
+		"Target":     subscriptionTargetName(sub),
+		"Delivery":   sub.Delivery,
+		"ConfirmURL": confirmURL,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send subscription confirmation: %v", err)
+		return err
+	}
+
+	log.Printf("Subscription confirmation queued for %s", sub.Email)
+	return nil
+}
+
+// SendModerationNotification tells a post's author that a comment is waiting for review
+func SendModerationNotification(ownerEmail, blogTitle, commenterName string) error {
+	err := sendTemplatedEmail(ownerEmail, "moderation", "", templates.Data{
+		"BlogTitle":     blogTitle,
+		"CommenterName": commenterName,
+		"ModerationURL": frontendURL("/moderation"),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send moderation notification: %v", err)
+		return err
+	}
+
+	log.Printf("Moderation notification queued for %s", ownerEmail)
+	return nil
+}
+
+// SendMentionNotification tells a user that someone mentioned them in a post or comment
+func SendMentionNotification(userEmail, mentionedBy, blogTitle, link string) error {
+	err := sendTemplatedEmail(userEmail, "mention", "", templates.Data{
+		"MentionedBy": mentionedBy,
+		"BlogTitle":   blogTitle,
+		"Link":        link,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send mention notification: %v", err)
+		return err
+	}
+
+	log.Printf("Mention notification queued for %s", userEmail)
+	return nil
+}
+
+// SendNotificationEmail sends a short notification with a link to what it is about
+func SendNotificationEmail(userEmail, message, link string) error {
+	err := sendTemplatedEmail(userEmail, "notification", "", templates.Data{
+		"Message": message,
+		"Link":    link,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send notification email: %v", err)
+		return err
+	}
+
+	log.Printf("Notification email queued for %s", userEmail)
 	return nil
 }
 
 // SendVerificationEmail sends an email verification email
 func SendVerificationEmail(userEmail, userName, verificationCode string) error {
-	verificationURL := fmt.Sprintf("%s/verify-email?code=%s", os.Getenv("FRONTEND_URL"), verificationCode)
-
-	err := SendEmail(EmailRequest{
-		To:      userEmail,
-		Subject: "Please verify your email address",
-		Text:    fmt.Sprintf("Hello %s, please verify your email by clicking this link: %s", userName, verificationURL),
-		HTML: fmt.Sprintf(`
-            <h2>Welcome %s!</h2>
-            <p>Thank you for signing up. Please verify your email address by clicking the button below:</p>
-            <a href="%s" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Verify Email</a>
-            <p>Or copy and paste this link: %s</p>
-            <p>This verification link will expire in 24 hours.</p>
-        `, userName, verificationURL, verificationURL),
+	err := sendTemplatedEmail(userEmail, "verify_email", secretKey("verify", verificationCode), templates.Data{
+		"UserName":        userName,
+		"VerificationURL": frontendURL("/verify-email?code=" + url.QueryEscape(verificationCode)),
 	})
 
 	if err != nil {
@@ -134,6 +338,76 @@ func SendVerificationEmail(userEmail, userName, verificationCode string) error {
 		return err
 	}
 
-	log.Printf("Verification email sent to %s", userEmail)
+	log.Printf("Verification email queued for %s", userEmail)
+	return nil
+}
+
+// SendEmailChangeConfirmation asks a user to confirm the new address they asked to change to
+func SendEmailChangeConfirmation(changeID, newEmail, userName, confirmURL string) error {
+	err := sendTemplatedEmail(newEmail, "email_change_confirm", "email-change:"+changeID, templates.Data{
+		"UserName":   userName,
+		"ConfirmURL": confirmURL,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send email change confirmation: %v", err)
+		return err
+	}
+
+	log.Printf("Email change confirmation queued for %s", newEmail)
+	return nil
+}
+
+// SendEmailChangeNotice tells a user's current address that a change to another address was requested
+func SendEmailChangeNotice(changeID, oldEmail, userName, newEmail string) error {
+	err := sendTemplatedEmail(oldEmail, "email_change_notice", "email-change-notice:"+changeID, templates.Data{
+		"UserName": userName,
+		"NewEmail": newEmail,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send email change notice: %v", err)
+		return err
+	}
+
+	log.Printf("Email change notice queued for %s", oldEmail)
+	return nil
+}
+
+// SendInvitationEmail invites someone to join with a role
+func SendInvitationEmail(invitation *models.Invitation, acceptURL string) error {
+	key := fmt.Sprintf("invitation:%s:%d", invitation.ID.Hex(), invitation.SendCount)
+	err := sendTemplatedEmail(invitation.Email, "invitation", key, templates.Data{
+		"InviterName": invitation.InvitedByName,
+		"Role":        invitation.Role,
+		"Message":     invitation.Message,
+		"AcceptURL":   acceptURL,
+		"ExpiresAt":   invitation.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send invitation email: %v", err)
+		return err
+	}
+
+	log.Printf("Invitation email queued for %s", invitation.Email)
+	return nil
+}
+
+// SendRoleRequestResult tells a user whether they got the role they asked for
+func SendRoleRequestResult(request *models.RoleRequest, to string) error {
+	err := sendTemplatedEmail(to, "role_request_result", "role-request:"+request.ID.Hex(), templates.Data{
+		"UserName":      request.UserName,
+		"RequestedRole": request.RequestedRole,
+		"Approved":      request.Status == models.RoleRequestApproved,
+		"Reason":        request.Reason,
+	})
+
+	if err != nil {
+		log.Printf("Failed to send role request result: %v", err)
+		return err
+	}
+
+	log.Printf("Role request result queued for %s", to)
 	return nil
 }
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"goserver/internal/mentions"
+	"goserver/internal/models"
+	"goserver/internal/ratelimit"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mentionLimiter caps how many mention notifications one author can send,
+// and one user can receive, per window.
+var mentionLimiter = ratelimit.New(10, time.Hour)
+
+// ConfigureMentionNotifications sets how many mention notifications may be
+// sent from, and to, any one user in each period.
+func ConfigureMentionNotifications(limit int, period time.Duration) {
+	mentionLimiter = ratelimit.New(limit, period)
+}
+
+// userNameCollation compares user names case-insensitively.
+var userNameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// resolveMentions looks up the users mentioned in text. Names that do not
+// belong to an active user are ignored.
+func resolveMentions(ctx context.Context, text string) ([]models.Mention, error) {
+	names := mentions.Parse(text)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := getCollection("users").Find(ctx,
+		notDeleted(bson.M{"user_name": bson.M{"$in": names}}),
+		options.Find().SetCollation(userNameCollation).SetProjection(bson.M{"user_name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]models.User, len(users))
+	for _, user := range users {
+		byName[strings.ToLower(user.UserName)] = user
+	}
+	var resolved []models.Mention
+	for _, name := range names {
+		if user, ok := byName[strings.ToLower(name)]; ok {
+			resolved = append(resolved, models.Mention{UserID: user.ID, UserName: user.UserName})
+		}
+	}
+	return resolved, nil
+}
+
+// addedMentions returns the mentions in current that are not in previous.
+func addedMentions(previous, current []models.Mention) []models.Mention {
+	known := make(map[primitive.ObjectID]bool, len(previous))
+	for _, mention := range previous {
+		known[mention.UserID] = true
+	}
+	var added []models.Mention
+	for _, mention := range current {
+		if !known[mention.UserID] {
+			added = append(added, mention)
+		}
+	}
+	return added
+}
+
+// mentionEvents turns mentions into notification events that share the
+// actor and location of base.
+func mentionEvents(base NotificationEvent, mentioned []models.Mention) []NotificationEvent {
+	events := make([]NotificationEvent, 0, len(mentioned))
+	for _, mention := range mentioned {
+		event := base
+		event.Type = models.NotificationMention
+		event.Recipient = mention.UserID
+		events = append(events, event)
+	}
+	return events
+}
+
+// AutocompleteUsers returns up to limit active users whose names start with
+// prefix, ignoring case. Only the ID and name of each are returned.
+func AutocompleteUsers(prefix string, limit int64) ([]models.Mention, error) {
+	collection, ctx, cancel := GetCollectionAndContext("users")
+	defer cancel()
+
+	filter := notDeleted(bson.M{"user_name": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}})
+	opts := options.Find().
+		SetSort(bson.D{{Key: "user_name", Value: 1}}).
+		SetLimit(limit).
+		SetProjection(bson.M{"user_name": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	matches := make([]models.Mention, 0, len(users))
+	for _, user := range users {
+		matches = append(matches, models.Mention{UserID: user.ID, UserName: user.UserName})
+	}
+	return matches, nil
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"goserver/internal/models"
+	"goserver/internal/realtime"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moderationActions maps a moderation action to the status it sets.
+var moderationActions = map[string]string{
+	"approve": models.CommentStatusApproved,
+	"reject":  models.CommentStatusRejected,
+	"spam":    models.CommentStatusSpam,
+}
+
+var ErrUnknownModerationAction = errors.New("action must be one of approve, reject or spam")
+
+// CommentActor identifies the user acting on a comment. Admins may moderate
+// any comment; anyone else only comments on their own posts.
+type CommentActor struct {
+	UserID   string
+	UserName string
+	Role     string
+}
+
+func (m CommentActor) isAdmin() bool {
+	return m.Role == models.USER_ROLES["ADMIN"].Name
+}
+
+// ModerationResult reports which comments a bulk action changed.
+type ModerationResult struct {
+	Updated []string `json:"updated"`
+	Skipped []string `json:"skipped"`
+}
+
+// IsBlogOwner reports whether the user wrote the blog. Posts linked to an
+// account are matched by ID, older ones by the author's user name.
+func IsBlogOwner(blog *models.Blog, userID, userName string) bool {
+	if blog.AuthorID != nil {
+		return userID != "" && blog.AuthorID.Hex() == userID
+	}
+	return userName != "" && blog.OwnerName == userName
+}
+
+// initialCommentStatus decides whether a new comment is published straight
+// away or held for review.
+func initialCommentStatus(ctx context.Context, comments *mongo.Collection, comment *models.Comment, blog *models.Blog, opts AddCommentOptions) (string, error) {
+	if opts.TrustedThreshold <= 0 || exemptFromModeration(blog, opts) {
+		return models.CommentStatusApproved, nil
+	}
+	if comment.CommenterID == "" {
+		return models.CommentStatusPending, nil
+	}
+
+	approved, err := comments.CountDocuments(ctx,
+		bson.M{"commenter_id": comment.CommenterID, "status": models.CommentStatusApproved},
+		options.Count().SetLimit(opts.TrustedThreshold))
+	if err != nil {
+		return "", err
+	}
+	if approved >= opts.TrustedThreshold {
+		return models.CommentStatusApproved, nil
+	}
+	return models.CommentStatusPending, nil
+}
+
+// exemptFromModeration reports whether the commenter is never held for
+// review or spam checked: Admins, and Creators on their own posts.
+func exemptFromModeration(blog *models.Blog, opts AddCommentOptions) bool {
+	if opts.Role == models.USER_ROLES["ADMIN"].Name {
+		return true
+	}
+	return opts.Role == models.USER_ROLES["CREATOR"].Name && IsBlogOwner(blog, opts.UserID, opts.UserName)
+}
+
+// applySpamVerdict holds or rejects a comment according to its spam score.
+// A high score can only make the outcome stricter, never looser.
+func applySpamVerdict(comment *models.Comment, verdict SpamVerdict, opts AddCommentOptions) {
+	comment.SpamScore = verdict.Score
+	comment.SpamReasons = verdict.Reasons
+	switch {
+	case opts.SpamRejectThreshold > 0 && verdict.Score >= opts.SpamRejectThreshold:
+		comment.Status = models.CommentStatusSpam
+	case opts.SpamHoldThreshold > 0 && verdict.Score >= opts.SpamHoldThreshold:
+		comment.Status = models.CommentStatusPending
+	}
+}
+
+// notifyCommentApproved tells a comment's author that it was approved and
+// then sends the notifications that were held back while it was pending.
+func notifyCommentApproved(comment models.Comment, moderator CommentActor) {
+	if recipient, err := primitive.ObjectIDFromHex(comment.CommenterID); err == nil {
+		event := commentEvent(&comment)
+		event.Type = models.NotificationCommentApproved
+		event.Recipient = recipient
+		event.ActorID = moderator.UserID
+		event.ActorName = moderator.UserName
+		dispatchNotifications([]NotificationEvent{event})
+	}
+	notifyCommentPublished(comment)
+}
+
+// GetModerationQueue lists comments in the given moderation state, oldest
+// first. Non-admin moderators only see comments on their own posts.
+func GetModerationQueue(status string, moderator CommentActor, limit, skip int64) ([]models.Comment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := notDeleted(bson.M{"status": status})
+	if !moderator.isAdmin() {
+		ids, err := blogIDsOwnedBy(ctx, moderator.UserID, moderator.UserName)
+		if err != nil {
+			return nil, err
+		}
+		filter["blog_id"] = bson.M{"$in": ids}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetLimit(limit).
+		SetSkip(skip)
+
+	cursor, err := getCollection("comments").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	comments := []models.Comment{}
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// ModerateComments applies a moderation action to each comment the
+// moderator is allowed to moderate. The rest are reported as skipped.
+func ModerateComments(ids []string, action string, moderator CommentActor) (*ModerationResult, error) {
+	status, ok := moderationActions[action]
+	if !ok {
+		return nil, ErrUnknownModerationAction
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result := &ModerationResult{Updated: []string{}, Skipped: []string{}}
+	var objIDs []primitive.ObjectID
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+		objIDs = append(objIDs, objID)
+	}
+	if len(objIDs) == 0 {
+		return result, nil
+	}
+
+	comments := getCollection("comments")
+	cursor, err := comments.Find(ctx, notDeleted(bson.M{"_id": bson.M{"$in": objIDs}, "placeholder": bson.M{"$ne": true}}))
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Comment
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	owned := map[primitive.ObjectID]bool{}
+	if !moderator.isAdmin() {
+		blogIDs, err := blogIDsOwnedBy(ctx, moderator.UserID, moderator.UserName)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range blogIDs {
+			owned[id] = true
+		}
+	}
+
+	allowed := map[string]bool{}
+	var allowedIDs []primitive.ObjectID
+	var allowedComments []models.Comment
+	for _, comment := range found {
+		if moderator.isAdmin() || owned[comment.BlogID] {
+			allowed[comment.ID.Hex()] = true
+			allowedIDs = append(allowedIDs, comment.ID)
+			allowedComments = append(allowedComments, comment)
+		}
+	}
+	for _, objID := range objIDs {
+		if allowed[objID.Hex()] {
+			result.Updated = append(result.Updated, objID.Hex())
+		} else {
+			result.Skipped = append(result.Skipped, objID.Hex())
+		}
+	}
+	if len(allowedIDs) == 0 {
+		return result, nil
+	}
+
+	now := time.Now()
+	_, err = comments.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": allowedIDs}}, bson.M{"$set": bson.M{
+		"status":      status,
+		"moderatedBy": moderator.UserID,
+		"moderatedAt": now,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	blogIDs := make([]primitive.ObjectID, 0, len(allowedComments))
+	for i := range allowedComments {
+		comment := &allowedComments[i]
+		learnModeration(comment, status)
+		blogIDs = append(blogIDs, comment.BlogID)
+		// A held comment is announced when it is first approved.
+		if status == models.CommentStatusApproved && comment.Status == models.CommentStatusPending && comment.ModeratedAt == nil {
+			go notifyCommentApproved(*comment, moderator)
+		}
+		switch wasPublished := isPublished(comment); {
+		case status == models.CommentStatusApproved && !wasPublished:
+			published := *comment
+			published.Status = status
+			publishComment(realtime.EventCommentCreated, published)
+		case status != models.CommentStatusApproved && wasPublished:
+			publishCommentDeleted(comment)
+		}
+	}
+	if err := refreshCommentCounts(ctx, blogIDs...); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// blogIDsOwnedBy returns the IDs of the live blogs written by the user.
+func blogIDsOwnedBy(ctx context.Context, userID, userName string) ([]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{}
+	filter := blogsByAuthor(userID, userName)
+	if filter == nil {
+		return ids, nil
+	}
+	cursor, err := getCollection("blogs").Find(ctx, notDeleted(filter),
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var blogs []models.Blog
+	if err := cursor.All(ctx, &blogs); err != nil {
+		return nil, err
+	}
+	for _, blog := range blogs {
+		ids = append(ids, blog.ID)
+	}
+	return ids, nil
+}
+
+// blogsByAuthor matches the blogs written by the user: those linked to their
+// account, and unlinked ones under their user name. It returns nil when
+// neither is known.
+func blogsByAuthor(userID, userName string) bson.M {
+	or := bson.A{}
+	if objID, err := primitive.ObjectIDFromHex(userID); err == nil {
+		or = append(or, bson.M{"author_id": objID})
+	}
+	if userName != "" {
+		or = append(or, bson.M{"author_id": nil, "blog_owner_name": userName})
+	}
+	if len(or) == 0 {
+		return nil
+	}
+	return bson.M{"$or": or}
+}
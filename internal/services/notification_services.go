@@ -0,0 +1,547 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"goserver/internal/models"
+	"goserver/internal/templates"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUnknownNotificationPref = errors.New("unknown notification event or channel")
+	ErrInvalidWebhookURL       = errors.New("webhook_url must be an https URL")
+	ErrInvalidLocale           = errors.New("locale must be a language tag such as en or pt-BR")
+)
+
+// localePattern matches the language tags emails can be localized to.
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// NotificationEvent is something that happened which Recipient should be
+// told about.
+type NotificationEvent struct {
+	Type      string
+	Recipient primitive.ObjectID
+	ActorID   string
+	ActorName string
+	BlogID    primitive.ObjectID
+	CommentID *primitive.ObjectID
+}
+
+// NotificationChannel delivers notifications to users one way, such as in
+// the app or by email.
+type NotificationChannel interface {
+	Name() string
+	Deliver(ctx context.Context, user *models.User, notification *models.Notification) error
+}
+
+var (
+	notificationChannelsMu sync.RWMutex
+	notificationChannels   = []NotificationChannel{
+		&InAppChannel{},
+		&EmailChannel{},
+		NewWebhookChannel(),
+	}
+)
+
+// SetNotificationChannels replaces the channels notifications are delivered on.
+func SetNotificationChannels(channels ...NotificationChannel) {
+	notificationChannelsMu.Lock()
+	defer notificationChannelsMu.Unlock()
+	notificationChannels = channels
+}
+
+// wantsNotification reports whether user wants event on channel.
+func wantsNotification(user *models.User, event, channel string) bool {
+	channels, ok := user.NotificationPrefs[event]
+	if !ok {
+		return channel != models.NotificationChannelWebhook
+	}
+	return contains(channels, channel)
+}
+
+// dispatchNotifications delivers each event to its recipient on every
+// channel they want it on. Users are never told about their own actions,
+// and each recipient gets at most one notification per call, from the
+// first event addressed to them. It is meant to run in its own goroutine.
+func dispatchNotifications(events []NotificationEvent) {
+	if len(events) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	notificationChannelsMu.RLock()
+	channels := notificationChannels
+	notificationChannelsMu.RUnlock()
+
+	blogs := map[primitive.ObjectID]*models.Blog{}
+	notified := map[primitive.ObjectID]bool{}
+	for _, event := range events {
+		if notified[event.Recipient] {
+			continue
+		}
+
+		var user models.User
+		err := getCollection("users").FindOne(ctx, notDeleted(bson.M{"_id": event.Recipient})).Decode(&user)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Failed to load user %s for a notification: %v", event.Recipient.Hex(), err)
+			}
+			continue
+		}
+		if user.ID.Hex() == event.ActorID || (event.ActorName != "" && user.UserName == event.ActorName) {
+			continue
+		}
+		notified[event.Recipient] = true
+
+		if event.Type == models.NotificationMention &&
+			!mentionLimiter.AllowAll("from:"+event.ActorName, "to:"+user.ID.Hex()) {
+			log.Printf("Mention notification from %s to %s skipped: rate limited", event.ActorName, user.UserName)
+			continue
+		}
+
+		blog, ok := blogs[event.BlogID]
+		if !ok {
+			blog = &models.Blog{}
+			if err := getCollection("blogs").FindOne(ctx, bson.M{"_id": event.BlogID}).Decode(blog); err != nil {
+				log.Printf("Failed to load blog %s for a notification: %v", event.BlogID.Hex(), err)
+				continue
+			}
+			blogs[event.BlogID] = blog
+		}
+
+		notification := newNotification(event, blog)
+		for _, channel := range channels {
+			if !wantsNotification(&user, event.Type, channel.Name()) {
+				continue
+			}
+			if err := channel.Deliver(ctx, &user, notification); err != nil {
+				log.Printf("Failed to deliver %s notification to %s by %s: %v", event.Type, user.UserName, channel.Name(), err)
+			}
+		}
+	}
+}
+
+// newNotification describes event for its recipient.
+func newNotification(event NotificationEvent, blog *models.Blog) *models.Notification {
+	siteURL := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/")
+	link := fmt.Sprintf("%s/blog/%s", siteURL, blog.ID.Hex())
+	if event.CommentID != nil {
+		link += "#comment-" + event.CommentID.Hex()
+	}
+
+	var message string
+	switch event.Type {
+	case models.NotificationMention:
+		message = fmt.Sprintf("%s mentioned you on \"%s\"", event.ActorName, blog.Subject)
+	case models.NotificationCommentOnPost:
+		message = fmt.Sprintf("%s commented on \"%s\"", event.ActorName, blog.Subject)
+	case models.NotificationCommentReply:
+		message = fmt.Sprintf("%s replied to your comment on \"%s\"", event.ActorName, blog.Subject)
+	case models.NotificationCommentApproved:
+		message = fmt.Sprintf("Your comment on \"%s\" was approved", blog.Subject)
+	case models.NotificationCommentPending:
+		message = fmt.Sprintf("%s left a comment on \"%s\" that is waiting for your review", event.ActorName, blog.Subject)
+		link = siteURL + "/moderation"
+	}
+
+	return &models.Notification{
+		ID:        primitive.NewObjectID(),
+		UserID:    event.Recipient,
+		Type:      event.Type,
+		ActorID:   event.ActorID,
+		ActorName: event.ActorName,
+		BlogID:    blog.ID,
+		CommentID: event.CommentID,
+		Title:     blog.Subject,
+		Message:   message,
+		Link:      link,
+		CreatedAt: time.Now(),
+	}
+}
+
+// InAppChannel stores notifications for the notification center.
+type InAppChannel struct{}
+
+func (c *InAppChannel) Name() string { return models.NotificationChannelInApp }
+
+func (c *InAppChannel) Deliver(ctx context.Context, user *models.User, notification *models.Notification) error {
+	_, err := getCollection("notifications").InsertOne(ctx, notification)
+	return err
+}
+
+// EmailChannel emails notifications to the user's address.
+type EmailChannel struct{}
+
+func (c *EmailChannel) Name() string { return models.NotificationChannelEmail }
+
+func (c *EmailChannel) Deliver(ctx context.Context, user *models.User, notification *models.Notification) error {
+	if user.UserEmail == "" {
+		return nil
+	}
+	switch notification.Type {
+	case models.NotificationMention:
+		return SendMentionNotification(user.UserEmail, notification.ActorName, notification.Title, notification.Link)
+	case models.NotificationCommentPending:
+		return SendModerationNotification(user.UserEmail, notification.Title, notification.ActorName)
+	default:
+		return SendNotificationEmail(user.UserEmail, notification.Message, notification.Link)
+	}
+}
+
+// WebhookChannel POSTs notifications as JSON to the URL the user set up.
+// The X-Signature-Timestamp header, a dot and the body are signed with
+// HMAC-SHA256 in the X-Signature-256 header, using the secret the user was
+// given when they set the URL. Receivers should refuse old timestamps so
+// that a captured request can't be replayed.
+type WebhookChannel struct {
+	client *http.Client
+}
+
+// NewWebhookChannel returns a WebhookChannel. It refuses to connect to
+// loopback, private and link-local addresses so that user-supplied URLs
+// can't reach internal services.
+func NewWebhookChannel() *WebhookChannel {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	return &WebhookChannel{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 5 * time.Second},
+		},
+	}
+}
+
+func (c *WebhookChannel) Name() string { return models.NotificationChannelWebhook }
+
+func (c *WebhookChannel) Deliver(ctx context.Context, user *models.User, notification *models.Notification) error {
+	if user.WebhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, user.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Notification-Type", notification.Type)
+	// Webhooks set up before secrets were issued go unsigned until the
+	// URL is set again
+	if user.WebhookSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(user.WebhookSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// MigrateNotificationEvents carries preferences and notifications for the
+// comment approval event over from its old name, post_approved.
+func MigrateNotificationEvents() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const oldName = "post_approved"
+	_, err := getCollection("users").UpdateMany(ctx,
+		bson.M{"notification_prefs." + oldName: bson.M{"$exists": true}},
+		bson.M{"$rename": bson.M{"notification_prefs." + oldName: "notification_prefs." + models.NotificationCommentApproved}})
+	if err != nil {
+		return err
+	}
+	_, err = getCollection("notifications").UpdateMany(ctx,
+		bson.M{"type": oldName},
+		bson.M{"$set": bson.M{"type": models.NotificationCommentApproved}})
+	return err
+}
+
+// findUserByName returns the active user with the given name, or nil.
+func findUserByName(ctx context.Context, userName string) (*models.User, error) {
+	if userName == "" {
+		return nil, nil
+	}
+	var user models.User
+	err := getCollection("users").FindOne(ctx, notDeleted(bson.M{"user_name": userName})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// blogAuthor returns the active account that wrote blog, or nil. Posts
+// linked to an account are resolved by ID, older ones by the byline.
+func blogAuthor(ctx context.Context, blog *models.Blog) (*models.User, error) {
+	if blog.AuthorID == nil {
+		return findUserByName(ctx, blog.OwnerName)
+	}
+	var user models.User
+	err := getCollection("users").FindOne(ctx, notDeleted(bson.M{"_id": *blog.AuthorID})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetNotifications returns a page of a user's notifications, newest first,
+// and the cursor of the next page, which is empty on the last page.
+func GetNotifications(userID string, unreadOnly bool, limit int64, cursor string) ([]models.Notification, string, error) {
+	collection, ctx, cancel := GetCollectionAndContext("notifications")
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	filter := bson.M{"user_id": objID}
+	if unreadOnly {
+		filter["read"] = false
+	}
+	if cursor != "" {
+		after, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		filter["_id"] = bson.M{"$lt": after}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit + 1)
+	found, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	notifications := []models.Notification{}
+	if err := found.All(ctx, &notifications); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if int64(len(notifications)) > limit {
+		notifications = notifications[:limit]
+		next = notifications[len(notifications)-1].ID.Hex()
+	}
+	return notifications, next, nil
+}
+
+// CountUnreadNotifications counts the notifications a user has not read.
+func CountUnreadNotifications(userID string) (int64, error) {
+	collection, ctx, cancel := GetCollectionAndContext("notifications")
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	return collection.CountDocuments(ctx, bson.M{"user_id": objID, "read": false})
+}
+
+// MarkNotificationRead marks one of a user's notifications as read.
+func MarkNotificationRead(userID, notificationID string) error {
+	collection, ctx, cancel := GetCollectionAndContext("notifications")
+	defer cancel()
+
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(notificationID)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "user_id": userObjID},
+		bson.M{"$set": bson.M{"read": true, "readAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of a user as
+// read and returns how many there were.
+func MarkAllNotificationsRead(userID string) (int64, error) {
+	collection, ctx, cancel := GetCollectionAndContext("notifications")
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	result, err := collection.UpdateMany(ctx,
+		bson.M{"user_id": objID, "read": false},
+		bson.M{"$set": bson.M{"read": true, "readAt": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetNotificationPrefs returns a user's notification preferences.
+func GetNotificationPrefs(userID string) (map[string][]string, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	prefs := user.NotificationPrefs
+	if prefs == nil {
+		prefs = map[string][]string{}
+	}
+	return prefs, nil
+}
+
+// SetNotificationPrefs replaces the preferences for the events in prefs,
+// leaving the others as they were.
+func SetNotificationPrefs(userID string, prefs map[string][]string) error {
+	set := bson.M{"updatedAt": time.Now()}
+	if err := setNotificationPrefs(set, prefs); err != nil {
+		return err
+	}
+	return updateOwnUser(userID, set)
+}
+
+// setNotificationPrefs validates prefs and adds them to an update.
+func setNotificationPrefs(set bson.M, prefs map[string][]string) error {
+	for event, channels := range prefs {
+		if !contains(models.NotificationEvents, event) {
+			return ErrUnknownNotificationPref
+		}
+		for _, channel := range channels {
+			if !contains(models.NotificationChannels, channel) {
+				return ErrUnknownNotificationPref
+			}
+		}
+		if channels == nil {
+			channels = []string{}
+		}
+		set["notification_prefs."+event] = channels
+	}
+	return nil
+}
+
+// SetWebhookURL sets the URL a user's webhook notifications are sent to and
+// returns a new secret they are signed with. The secret is not shown again;
+// setting the URL again replaces it. An empty URL removes the webhook.
+func SetWebhookURL(userID, webhookURL string) (string, error) {
+	if webhookURL == "" {
+		return "", updateOwnUser(userID, bson.M{"webhook_url": "", "webhook_secret": "", "updatedAt": time.Now()})
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", ErrInvalidWebhookURL
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(key)
+	return secret, updateOwnUser(userID, bson.M{"webhook_url": webhookURL, "webhook_secret": secret, "updatedAt": time.Now()})
+}
+
+// SetUserLocale sets the language a user's email is sent in. An empty locale
+// goes back to the site default.
+func SetUserLocale(userID, locale string) (string, error) {
+	locale, err := normalizeUserLocale(locale)
+	if err != nil {
+		return "", err
+	}
+	return locale, updateOwnUser(userID, bson.M{"locale": locale, "updatedAt": time.Now()})
+}
+
+func normalizeUserLocale(locale string) (string, error) {
+	locale = templates.NormalizeLocale(locale)
+	if locale != "" && !localePattern.MatchString(locale) {
+		return "", ErrInvalidLocale
+	}
+	return locale, nil
+}
+
+func updateOwnUser(userID string, set bson.M) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("users")
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, notDeleted(bson.M{"_id": objID}), bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
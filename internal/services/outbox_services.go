@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"goserver/internal/mailer"
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrEmailNotRetryable = errors.New("only dead or queued email can be retried")
+
+// OutboxOptions tunes delivery from the email outbox.
+type OutboxOptions struct {
+	Workers int
+	// An email that fails MaxAttempts times is dead-lettered. Retries wait
+	// BaseBackoff, doubling after each failure up to MaxBackoff.
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+}
+
+const (
+	// emailSendTimeout bounds a single delivery attempt.
+	emailSendTimeout = 30 * time.Second
+	// emailLease is how long a worker may hold an email before another
+	// worker assumes it crashed and takes the email over.
+	emailLease = 4 * emailSendTimeout
+)
+
+// outboxWake nudges an idle worker when email is queued, so delivery
+// doesn't wait for the next poll.
+var outboxWake = make(chan struct{}, 1)
+
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueEmail stores req in the outbox. Queueing an email whose
+// idempotency key is already in the outbox does nothing.
+func enqueueEmail(req EmailRequest) error {
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	now := time.Now()
+	_, err := collection.InsertOne(ctx, models.OutboxEmail{
+		IdempotencyKey: req.IdempotencyKey,
+		To:             req.To,
+		Subject:        req.Subject,
+		Text:           req.Text,
+		HTML:           req.HTML,
+		Headers:        req.Headers,
+		Sensitive:      req.Sensitive,
+		Status:         models.EmailStatusQueued,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if req.IdempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+		log.Printf("Email %q is already queued", req.IdempotencyKey)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	wakeOutbox()
+	return nil
+}
+
+// StartEmailWorkers starts the workers that deliver email from the outbox.
+func StartEmailWorkers(opts OutboxOptions) {
+	for i := 0; i < opts.Workers; i++ {
+		go emailWorker(opts)
+	}
+}
+
+func emailWorker(opts OutboxOptions) {
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			email, err := claimEmail()
+			if err != nil {
+				log.Printf("Error claiming queued email: %v", err)
+				break
+			}
+			if email == nil {
+				break
+			}
+			// There may be more waiting; let another worker look.
+			wakeOutbox()
+			deliverOutboxEmail(email, opts)
+		}
+		select {
+		case <-outboxWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimEmail leases the next email that is due, or one whose worker's lease
+// ran out. It returns nil when there is nothing to send.
+func claimEmail() (*models.OutboxEmail, error) {
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": models.EmailStatusQueued, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"status": models.EmailStatusSending, "locked_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":       models.EmailStatusSending,
+		"locked_until": now.Add(emailLease),
+		"updatedAt":    now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var email models.OutboxEmail
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&email)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+// deliverOutboxEmail sends a claimed email and records the outcome. The
+// update only applies while the worker still holds the lease.
+func deliverOutboxEmail(email *models.OutboxEmail, opts OutboxOptions) {
+	sendErr := sendOutboxEmail(email)
+
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{"updatedAt": now}
+	unset := bson.M{"locked_until": ""}
+	switch {
+	case sendErr == nil:
+		set["status"] = models.EmailStatusSent
+		set["sentAt"] = now
+		unset["last_error"] = ""
+		clearSensitiveBody(email, unset)
+	case errors.Is(sendErr, ErrEmailSuppressed):
+		set["status"] = models.EmailStatusSuppressed
+		set["last_error"] = sendErr.Error()
+		clearSensitiveBody(email, unset)
+		log.Printf("Not sending email %s to suppressed address %s", email.ID.Hex(), email.To)
+	default:
+		attempts := email.Attempts + 1
+		set["attempts"] = attempts
+		set["last_error"] = sendErr.Error()
+		if attempts >= opts.MaxAttempts {
+			set["status"] = models.EmailStatusDead
+			log.Printf("Giving up on email %s to %s after %d attempts: %v", email.ID.Hex(), email.To, attempts, sendErr)
+		} else {
+			set["status"] = models.EmailStatusQueued
+			set["next_attempt_at"] = now.Add(emailBackoff(attempts, opts))
+			log.Printf("Failed to send email %s to %s, will retry: %v", email.ID.Hex(), email.To, sendErr)
+		}
+	}
+
+	filter := bson.M{"_id": email.ID, "status": models.EmailStatusSending, "locked_until": email.LockedUntil}
+	if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": set, "$unset": unset}); err != nil {
+		log.Printf("Error recording delivery of email %s: %v", email.ID.Hex(), err)
+	}
+}
+
+// clearSensitiveBody drops the body of a sensitive email that won't be sent
+// again, so its links don't sit in the outbox.
+func clearSensitiveBody(email *models.OutboxEmail, unset bson.M) {
+	if email.Sensitive {
+		unset["text"] = ""
+		unset["html"] = ""
+		unset["headers"] = ""
+	}
+}
+
+// redactOutboxEmail hides the body of a sensitive email from the API.
+func redactOutboxEmail(email *models.OutboxEmail) {
+	if email.Sensitive {
+		email.Text = ""
+		email.HTML = ""
+		email.Headers = nil
+	}
+}
+
+func sendOutboxEmail(email *models.OutboxEmail) error {
+	if activeMailer == nil {
+		return ErrMailerNotConfigured
+	}
+	suppressed, err := IsEmailSuppressed(email.To)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return ErrEmailSuppressed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+	defer cancel()
+	return activeMailer.Send(ctx, mailer.Message{
+		From:    mailFrom,
+		To:      email.To,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+		Headers: email.Headers,
+	})
+}
+
+// emailBackoff returns how long to wait after the given number of failed
+// attempts, with some jitter so retries from an outage don't all land at
+// once.
+func emailBackoff(attempts int, opts OutboxOptions) time.Duration {
+	backoff := opts.BaseBackoff
+	for i := 1; i < attempts && backoff < opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > opts.MaxBackoff {
+		backoff = opts.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// GetOutboxEmails lists outbox email in the given state, newest first.
+// Sensitive email is listed without its body.
+func GetOutboxEmails(status string, limit, skip int64) ([]models.OutboxEmail, error) {
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(skip)
+	cursor, err := collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, err
+	}
+	emails := []models.OutboxEmail{}
+	if err := cursor.All(ctx, &emails); err != nil {
+		return nil, err
+	}
+	for i := range emails {
+		redactOutboxEmail(&emails[i])
+	}
+	return emails, nil
+}
+
+// GetOutboxEmail returns one outbox email, without the body if it is
+// sensitive.
+func GetOutboxEmail(id string) (*models.OutboxEmail, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	var email models.OutboxEmail
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&email); err != nil {
+		return nil, err
+	}
+	redactOutboxEmail(&email)
+	return &email, nil
+}
+
+// retryEmailUpdate queues email again with a fresh set of attempts.
+func retryEmailUpdate(now time.Time) bson.M {
+	return bson.M{"$set": bson.M{
+		"status":          models.EmailStatusQueued,
+		"attempts":        0,
+		"next_attempt_at": now,
+		"updatedAt":       now,
+	}}
+}
+
+// RetryOutboxEmail queues a dead email for delivery again, or sends a
+// queued one now instead of waiting for its backoff.
+func RetryOutboxEmail(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	filter := bson.M{"_id": objID, "status": bson.M{"$in": bson.A{models.EmailStatusDead, models.EmailStatusQueued}}}
+	res, err := collection.UpdateOne(ctx, filter, retryEmailUpdate(time.Now()))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Err(); err != nil {
+			return err
+		}
+		return ErrEmailNotRetryable
+	}
+	wakeOutbox()
+	return nil
+}
+
+// RetryDeadEmails queues every dead email for delivery again.
+func RetryDeadEmails() (int64, error) {
+	collection, ctx, cancel := GetCollectionAndContext("email_outbox")
+	defer cancel()
+
+	res, err := collection.UpdateMany(ctx, bson.M{"status": models.EmailStatusDead}, retryEmailUpdate(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	if res.ModifiedCount > 0 {
+		wakeOutbox()
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Limits on what users may put in their profile.
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxURLLength         = 2048
+	maxProfileLinks      = 5
+	maxLinkLabelLength   = 40
+	minPasswordLength    = 8
+)
+
+var (
+	ErrDisplayNameTooLong = errors.New("display_name must be at most 50 characters")
+	ErrBioTooLong         = errors.New("bio must be at most 500 characters")
+	ErrInvalidAvatarURL   = errors.New("avatar_url must be an http or https URL")
+	ErrInvalidLinks       = errors.New("links must be at most 5 http or https URLs with labels of at most 40 characters")
+	ErrWeakPassword       = errors.New("new password must be at least 8 characters")
+)
+
+// ProfileUpdate holds the profile fields a user is changing. Nil fields are
+// left as they are, and an empty string clears a field.
+type ProfileUpdate struct {
+	DisplayName       *string
+	Bio               *string
+	AvatarURL         *string
+	Links             *[]models.ProfileLink
+	Locale            *string
+	NotificationPrefs map[string][]string
+}
+
+// GetProfile returns a user's own view of their account.
+func GetProfile(userID string) (*models.Profile, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	profile := user.Profile()
+	return &profile, nil
+}
+
+// UpdateProfile changes the safe fields of a user's own account. Email,
+// role and password have their own flows.
+func UpdateProfile(userID string, update ProfileUpdate) (*models.Profile, error) {
+	set := bson.M{"updatedAt": time.Now()}
+
+	if update.DisplayName != nil {
+		name := strings.Join(strings.FieldsFunc(*update.DisplayName, unicode.IsSpace), " ")
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			return nil, ErrDisplayNameTooLong
+		}
+		set["display_name"] = stripControl(name, false)
+	}
+	if update.Bio != nil {
+		bio := strings.TrimSpace(*update.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return nil, ErrBioTooLong
+		}
+		set["bio"] = stripControl(bio, true)
+	}
+	if update.AvatarURL != nil {
+		avatar := strings.TrimSpace(*update.AvatarURL)
+		if avatar != "" && !validWebURL(avatar) {
+			return nil, ErrInvalidAvatarURL
+		}
+		set["avatar_url"] = avatar
+	}
+	if update.Links != nil {
+		links := *update.Links
+		if len(links) > maxProfileLinks {
+			return nil, ErrInvalidLinks
+		}
+		cleaned := make([]models.ProfileLink, 0, len(links))
+		for _, link := range links {
+			label := stripControl(strings.TrimSpace(link.Label), false)
+			target := strings.TrimSpace(link.URL)
+			if label == "" || utf8.RuneCountInString(label) > maxLinkLabelLength || !validWebURL(target) {
+				return nil, ErrInvalidLinks
+			}
+			cleaned = append(cleaned, models.ProfileLink{Label: label, URL: target})
+		}
+		set["links"] = cleaned
+	}
+	if update.Locale != nil {
+		locale, err := normalizeUserLocale(*update.Locale)
+		if err != nil {
+			return nil, err
+		}
+		set["locale"] = locale
+	}
+	if err := setNotificationPrefs(set, update.NotificationPrefs); err != nil {
+		return nil, err
+	}
+
+	if err := updateOwnUser(userID, set); err != nil {
+		return nil, err
+	}
+	return GetProfile(userID)
+}
+
+// validWebURL reports whether s is an absolute http or https URL.
+func validWebURL(s string) bool {
+	if len(s) > maxURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// stripControl removes control characters, keeping line breaks if asked.
+func stripControl(s string, keepNewlines bool) string {
+	return strings.Map(func(r rune) rune {
+		if keepNewlines && r == '\n' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// ChangePassword sets a new password for a user who knows their current one.
+func ChangePassword(userID, currentPassword, newPassword string) error {
+	if utf8.RuneCountInString(newPassword) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return mongo.ErrNoDocuments
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.UserPassword), []byte(currentPassword)) != nil {
+		return ErrWrongPassword
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("users")
+	defer cancel()
+
+	// Only apply the change if the password wasn't changed in the meantime
+	result, err := collection.UpdateOne(ctx,
+		notDeleted(bson.M{"_id": user.ID, "user_password": user.UserPassword}),
+		bson.M{"$set": bson.M{"user_password": string(hashed), "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrWrongPassword
+	}
+
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditPasswordChanged,
+		ActorID:    userID,
+		ActorName:  user.UserName,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+	return nil
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// reactionTargets maps a reaction target type to the collection holding it.
+var reactionTargets = map[string]string{
+	models.ReactionTargetBlog:    "blogs",
+	models.ReactionTargetComment: "comments",
+}
+
+var ErrUnknownReactionTarget = errors.New("reactions are only supported on blog and comment")
+
+// ReactionActor identifies who is reacting. Anonymous actors are keyed by a
+// hash of their network identity instead of a user ID.
+type ReactionActor struct {
+	UserID    string
+	UserName  string
+	Anonymous bool
+}
+
+// Reactor is a single entry in a who-reacted listing.
+type Reactor struct {
+	UserID    string    `json:"user_id,omitempty"`
+	UserName  string    `json:"user_name,omitempty"`
+	Anonymous bool      `json:"anonymous,omitempty"`
+	ReactedAt time.Time `json:"reactedAt"`
+}
+
+func reactionTarget(targetType, targetID string) (*mongo.Collection, primitive.ObjectID, error) {
+	collectionName, ok := reactionTargets[targetType]
+	if !ok {
+		return nil, primitive.NilObjectID, ErrUnknownReactionTarget
+	}
+	objID, err := primitive.ObjectIDFromHex(targetID)
+	if err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+	return getCollection(collectionName), objID, nil
+}
+
+// SetReaction records actor's reaction on a blog or comment. It is
+// idempotent: reacting twice with the same type counts once. The returned
+// flag reports whether a new reaction was recorded.
+func SetReaction(targetType, targetID, reactionType string, actor ReactionActor) (bool, error) {
+	target, objID, err := reactionTarget(targetType, targetID)
+	if err != nil {
+		return false, err
+	}
+
+	var added bool
+	err = withTransaction(func(ctx context.Context) error {
+		added = false
+		if err := target.FindOne(ctx, notDeleted(bson.M{"_id": objID})).Err(); err != nil {
+			return err
+		}
+
+		filter := bson.M{
+			"target_type":   targetType,
+			"target_id":     objID,
+			"user_id":       actor.UserID,
+			"reaction_type": reactionType,
+		}
+		insert := bson.M{"$setOnInsert": models.Reaction{
+			TargetType: targetType,
+			TargetID:   objID,
+			Type:       reactionType,
+			UserID:     actor.UserID,
+			UserName:   actor.UserName,
+			Anonymous:  actor.Anonymous,
+			CreatedAt:  time.Now(),
+		}}
+
+		res, err := getCollection("reactions").UpdateOne(ctx, filter, insert, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			return nil // A concurrent request recorded the same reaction
+		}
+		if err != nil {
+			return err
+		}
+		if res.UpsertedCount == 0 {
+			return nil
+		}
+
+		added = true
+		_, err = target.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$inc": reactionIncrement(targetType, reactionType, 1)})
+		return err
+	})
+	return added, err
+}
+
+// RemoveReaction withdraws actor's reaction. Removing a reaction that does
+// not exist is not an error. The returned flag reports whether one was removed.
+func RemoveReaction(targetType, targetID, reactionType string, actor ReactionActor) (bool, error) {
+	target, objID, err := reactionTarget(targetType, targetID)
+	if err != nil {
+		return false, err
+	}
+
+	var removed bool
+	err = withTransaction(func(ctx context.Context) error {
+		removed = false
+		res, err := getCollection("reactions").DeleteOne(ctx, bson.M{
+			"target_type":   targetType,
+			"target_id":     objID,
+			"user_id":       actor.UserID,
+			"reaction_type": reactionType,
+		})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return nil
+		}
+
+		removed = true
+		_, err = target.UpdateOne(ctx,
+			bson.M{"_id": objID, "reactions." + reactionType: bson.M{"$gt": 0}},
+			bson.M{"$inc": reactionIncrement(targetType, reactionType, -1)})
+		return err
+	})
+	return removed, err
+}
+
+// reactionIncrement changes the count of one reaction type by delta.
+// Comments also keep a running total so they can be sorted by reactions.
+func reactionIncrement(targetType, reactionType string, delta int) bson.M {
+	inc := bson.M{"reactions." + reactionType: delta}
+	if targetType == models.ReactionTargetComment {
+		inc["reaction_total"] = delta
+	}
+	return inc
+}
+
+// GetReactionCounts returns the denormalized reaction counts of a target.
+func GetReactionCounts(targetType, targetID string) (map[string]int64, error) {
+	target, objID, err := reactionTarget(targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var doc struct {
+		Reactions map[string]int64 `bson:"reactions"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"reactions": 1})
+	if err := target.FindOne(ctx, notDeleted(bson.M{"_id": objID}), opts).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Reactions == nil {
+		doc.Reactions = map[string]int64{}
+	}
+	return doc.Reactions, nil
+}
+
+// GetReactors lists who reacted to a target, grouped by reaction type.
+// Anonymous reactors are included without any identifying details.
+func GetReactors(targetType, targetID, reactionType string) (map[string][]Reactor, error) {
+	if _, ok := reactionTargets[targetType]; !ok {
+		return nil, ErrUnknownReactionTarget
+	}
+	objID, err := primitive.ObjectIDFromHex(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("reactions")
+	defer cancel()
+
+	filter := bson.M{"target_type": targetType, "target_id": objID}
+	if reactionType != "" {
+		filter["reaction_type"] = reactionType
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reactors := map[string][]Reactor{}
+	for cursor.Next(ctx) {
+		var reaction models.Reaction
+		if err := cursor.Decode(&reaction); err != nil {
+			return nil, err
+		}
+		reactor := Reactor{Anonymous: reaction.Anonymous, ReactedAt: reaction.CreatedAt}
+		if !reaction.Anonymous {
+			reactor.UserID = reaction.UserID
+			reactor.UserName = reaction.UserName
+		}
+		reactors[reaction.Type] = append(reactors[reaction.Type], reactor)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return reactors, nil
+}
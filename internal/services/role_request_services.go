@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRoleNotRequestable   = errors.New("you can only ask for a role above your own and below Admin")
+	ErrJustificationLength  = errors.New("justification must be between 10 and 1000 characters")
+	ErrRoleRequestPending   = errors.New("you already have a role request waiting for review")
+	ErrRoleRequestTooSoon   = errors.New("your last role request was denied recently")
+	ErrRoleRequestReason    = errors.New("reason is required to deny a request and must be at most 1000 characters")
+	ErrRoleRequestDecided   = errors.New("role request has already been decided")
+	ErrRoleRequestUserMoved = errors.New("the user's role has changed since they asked")
+)
+
+// Limits on role requests.
+const (
+	minJustificationLength = 10
+	maxJustificationLength = 1000
+	maxRoleDecisionReason  = 1000
+)
+
+// roleRequestCooldown is how long a user must wait after a denial before
+// asking again.
+var roleRequestCooldown = 7 * 24 * time.Hour
+
+// ConfigureRoleRequests sets how long users must wait after a denied role
+// request before they can ask again.
+func ConfigureRoleRequests(cooldown time.Duration) {
+	roleRequestCooldown = cooldown
+}
+
+// RequestRole asks the admins to give a user a higher role. A user can only
+// have one request waiting at a time, and must wait a while after a denial.
+func RequestRole(userID, role, justification string) (*models.RoleRequest, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	role = roleByName(role)
+	level := roleLevel(role)
+	if level <= roleLevel(user.Role) || level >= models.USER_ROLES["ADMIN"].Level {
+		return nil, ErrRoleNotRequestable
+	}
+	justification = stripControl(strings.TrimSpace(justification), true)
+	if n := utf8.RuneCountInString(justification); n < minJustificationLength || n > maxJustificationLength {
+		return nil, ErrJustificationLength
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := getCollection("role_requests")
+	now := time.Now()
+	var denied models.RoleRequest
+	err = collection.FindOne(ctx,
+		bson.M{"user_id": user.ID, "status": models.RoleRequestDenied, "decidedAt": bson.M{"$gt": now.Add(-roleRequestCooldown)}},
+		options.FindOne().SetSort(bson.D{{Key: "decidedAt", Value: -1}})).Decode(&denied)
+	if err == nil {
+		retry := denied.DecidedAt.Add(roleRequestCooldown)
+		return nil, fmt.Errorf("%w; you can ask again after %s", ErrRoleRequestTooSoon, retry.UTC().Format(time.RFC3339))
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	request := models.RoleRequest{
+		ID:            primitive.NewObjectID(),
+		UserID:        user.ID,
+		UserName:      user.UserName,
+		CurrentRole:   user.Role,
+		RequestedRole: role,
+		Justification: justification,
+		Status:        models.RoleRequestPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	// A unique index allows only one pending request per user
+	if _, err := collection.InsertOne(ctx, request); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrRoleRequestPending
+		}
+		return nil, err
+	}
+
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditRoleRequested,
+		ActorID:    userID,
+		ActorName:  user.UserName,
+		TargetType: "role_request",
+		TargetID:   request.ID.Hex(),
+		Details:    map[string]string{"current_role": user.Role, "requested_role": role},
+	})
+	return &request, nil
+}
+
+// GetUserRoleRequests lists a user's own role requests, newest first.
+func GetUserRoleRequests(userID string) ([]models.RoleRequest, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return findRoleRequests(bson.M{"user_id": objID}, 0, 0)
+}
+
+// GetRoleRequests lists role requests for the admin queue, optionally only
+// those with the given status. Pending requests come oldest first so the
+// queue is worked in order; anything else newest first.
+func GetRoleRequests(status string, limit, skip int64) ([]models.RoleRequest, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	return findRoleRequests(filter, limit, skip)
+}
+
+func findRoleRequests(filter bson.M, limit, skip int64) ([]models.RoleRequest, error) {
+	collection, ctx, cancel := GetCollectionAndContext("role_requests")
+	defer cancel()
+
+	order := -1
+	if filter["status"] == models.RoleRequestPending {
+		order = 1
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: order}})
+	if limit > 0 {
+		opts.SetSkip(skip).SetLimit(limit)
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	requests := []models.RoleRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// DecideRoleRequest approves or denies a pending role request and emails
+// the user the outcome. Approving gives the user the requested role, as
+// long as their role is still the one they asked from. A denial needs a
+// reason.
+func DecideRoleRequest(id string, admin CommentActor, approve bool, reason string) (*models.RoleRequest, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	reason = stripControl(strings.TrimSpace(reason), true)
+	if utf8.RuneCountInString(reason) > maxRoleDecisionReason || (!approve && reason == "") {
+		return nil, ErrRoleRequestReason
+	}
+
+	status := models.RoleRequestDenied
+	if approve {
+		status = models.RoleRequestApproved
+	}
+
+	var request models.RoleRequest
+	err = withTransaction(func(ctx context.Context) error {
+		collection := getCollection("role_requests")
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&request); err != nil {
+			return err
+		}
+		if request.Status != models.RoleRequestPending {
+			return ErrRoleRequestDecided
+		}
+
+		if approve {
+			if err := setUserRole(ctx, request.UserID, request.CurrentRole, request.RequestedRole); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		set := bson.M{
+			"status":          status,
+			"reason":          reason,
+			"decided_by":      admin.UserID,
+			"decided_by_name": admin.UserName,
+			"decidedAt":       now,
+			"updatedAt":       now,
+		}
+		return collection.FindOneAndUpdate(ctx,
+			bson.M{"_id": objID, "status": models.RoleRequestPending},
+			bson.M{"$set": set},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&request)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) && request.ID == objID {
+		// Decided by someone else between the read and the update
+		return nil, ErrRoleRequestDecided
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	recordAudit(ctx, models.AuditEntry{
+		Action:     models.AuditRoleRequestDecided,
+		ActorID:    admin.UserID,
+		ActorName:  admin.UserName,
+		TargetType: "role_request",
+		TargetID:   request.ID.Hex(),
+		Details:    map[string]string{"status": status, "requested_role": request.RequestedRole, "reason": reason},
+	})
+	if approve {
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditRoleChanged,
+			ActorID:    admin.UserID,
+			ActorName:  admin.UserName,
+			TargetType: "user",
+			TargetID:   request.UserID.Hex(),
+			Details:    map[string]string{"old_role": request.CurrentRole, "new_role": request.RequestedRole},
+		})
+	}
+
+	go notifyRoleDecision(request)
+	return &request, nil
+}
+
+// setUserRole changes a user's role from oldRole to newRole, provided it is
+// still oldRole.
+func setUserRole(ctx context.Context, userID primitive.ObjectID, oldRole, newRole string) error {
+	result, err := getCollection("users").UpdateOne(ctx,
+		notDeleted(bson.M{"_id": userID, "role": oldRole}),
+		bson.M{"$set": bson.M{"role": newRole, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRoleRequestUserMoved
+	}
+	return nil
+}
+
+func notifyRoleDecision(request models.RoleRequest) {
+	user, err := GetUserByID(request.UserID.Hex())
+	if err != nil {
+		log.Printf("Error loading user for role request %s: %v", request.ID.Hex(), err)
+		return
+	}
+	if user == nil || user.UserEmail == "" {
+		return
+	}
+	if err := SendRoleRequestResult(&request, user.UserEmail); err != nil {
+		log.Printf("Error sending role request result %s: %v", request.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sitemapTTL bounds how stale the cached sitemap can get if the blogs
+// collection is changed outside SaveBlog/DeleteBlog.
+const sitemapTTL = time.Hour
+
+// SitemapURL is a single <url> entry in a sitemap.
+type SitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+// Sitemap is a generated list of site URLs and the time it was built.
+type Sitemap struct {
+	URLs        []SitemapURL
+	GeneratedAt time.Time
+}
+
+var sitemapCache struct {
+	sync.Mutex
+	sitemap *Sitemap
+	siteURL string
+}
+
+// InvalidateSitemap drops the cached sitemap so the next request rebuilds it.
+func InvalidateSitemap() {
+	sitemapCache.Lock()
+	sitemapCache.sitemap = nil
+	sitemapCache.Unlock()
+}
+
+// GetSitemap returns the cached sitemap for siteURL, rebuilding it from the
+// blogs collection when it has been invalidated or has expired.
+func GetSitemap(siteURL string) (*Sitemap, error) {
+	sitemapCache.Lock()
+	defer sitemapCache.Unlock()
+
+	cached := sitemapCache.sitemap
+	if cached != nil && sitemapCache.siteURL == siteURL && time.Since(cached.GeneratedAt) < sitemapTTL {
+		return cached, nil
+	}
+
+	sitemap, err := buildSitemap(strings.TrimRight(siteURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	sitemapCache.sitemap = sitemap
+	sitemapCache.siteURL = siteURL
+	return sitemap, nil
+}
+
+func buildSitemap(siteURL string) (*Sitemap, error) {
+	collection, ctx, cancel := GetCollectionAndContext("blogs")
+	defer cancel()
+
+	opts := options.Find().
+		SetProjection(bson.M{"createdAt": 1, "updatedAt": 1, "blog_category": 1, "blog_owner_name": 1}).
+		SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, notDeleted(bson.M{}), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var posts []SitemapURL
+	var latest time.Time
+	categories := map[string]time.Time{}
+	authors := map[string]time.Time{}
+
+	for cursor.Next(ctx) {
+		var blog struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			Category  string             `bson:"blog_category"`
+			OwnerName string             `bson:"blog_owner_name"`
+			CreatedAt time.Time          `bson:"createdAt"`
+			UpdatedAt time.Time          `bson:"updatedAt"`
+		}
+		if err := cursor.Decode(&blog); err != nil {
+			return nil, err
+		}
+
+		lastMod := blog.UpdatedAt
+		if lastMod.IsZero() {
+			lastMod = blog.CreatedAt
+		}
+		if lastMod.After(latest) {
+			latest = lastMod
+		}
+
+		posts = append(posts, SitemapURL{Loc: siteURL + "/blog/" + blog.ID.Hex(), LastMod: lastMod})
+		if blog.Category != "" && !lastMod.Before(categories[blog.Category]) {
+			categories[blog.Category] = lastMod
+		}
+		if blog.OwnerName != "" && !lastMod.Before(authors[blog.OwnerName]) {
+			authors[blog.OwnerName] = lastMod
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	urls := []SitemapURL{{Loc: siteURL + "/", LastMod: latest}}
+	urls = append(urls, posts...)
+	urls = append(urls, groupURLs(siteURL+"/category/", categories)...)
+	urls = append(urls, groupURLs(siteURL+"/author/", authors)...)
+
+	return &Sitemap{URLs: urls, GeneratedAt: time.Now()}, nil
+}
+
+func groupURLs(prefix string, groups map[string]time.Time) []SitemapURL {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	urls := make([]SitemapURL, 0, len(names))
+	for _, name := range names {
+		urls = append(urls, SitemapURL{Loc: prefix + url.PathEscape(name), LastMod: groups[name]})
+	}
+	return urls
+}
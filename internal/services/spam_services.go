@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goserver/internal/models"
+	"goserver/internal/spam"
+	"goserver/internal/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// spamTrainingLimit caps how many comments of each class are loaded when
+// the classifier is trained at startup.
+const spamTrainingLimit = 5000
+
+// SpamInput is what a SpamChecker gets to look at. Honeypot and Elapsed come
+// from the submitted form rather than the comment itself. Elapsed is only
+// known when FormTimed is set, i.e. the form carried a valid form token.
+// Edit is set when an existing comment's new body is being checked, which
+// has no form.
+type SpamInput struct {
+	Comment   *models.Comment
+	Honeypot  string
+	Elapsed   time.Duration
+	FormTimed bool
+	Edit      bool
+}
+
+// SpamChecker scores a new comment from 0 (looks fine) to 1 (certainly spam).
+type SpamChecker interface {
+	Name() string
+	Check(ctx context.Context, in *SpamInput) (float64, error)
+}
+
+// SpamVerdict is the combined result of every registered checker. Reasons
+// names the checkers that scored the comment above zero.
+type SpamVerdict struct {
+	Score   float64
+	Reasons []string
+}
+
+type weightedChecker struct {
+	checker SpamChecker
+	weight  float64
+}
+
+var (
+	spamCheckersMu sync.RWMutex
+	spamCheckers   []weightedChecker
+
+	spamClassifier = spam.NewClassifier(5)
+)
+
+// RegisterSpamChecker adds a checker whose score counts weight times
+// towards a comment's total spam score.
+func RegisterSpamChecker(checker SpamChecker, weight float64) {
+	spamCheckersMu.Lock()
+	defer spamCheckersMu.Unlock()
+	spamCheckers = append(spamCheckers, weightedChecker{checker: checker, weight: weight})
+}
+
+// CheckSpam runs every registered checker over in and sums their weighted
+// scores. A checker that fails is logged and skipped.
+func CheckSpam(ctx context.Context, in *SpamInput) SpamVerdict {
+	spamCheckersMu.RLock()
+	checkers := spamCheckers
+	spamCheckersMu.RUnlock()
+
+	verdict := SpamVerdict{}
+	for _, wc := range checkers {
+		score, err := wc.checker.Check(ctx, in)
+		if err != nil {
+			log.Printf("Spam checker %s failed: %v", wc.checker.Name(), err)
+			continue
+		}
+		if score <= 0 {
+			continue
+		}
+		verdict.Score += wc.weight * math.Min(score, 1)
+		verdict.Reasons = append(verdict.Reasons, wc.checker.Name())
+	}
+	return verdict
+}
+
+// LinkChecker flags comments with more than MaxLinks links, or any link to
+// a blocked domain (or one of its subdomains).
+type LinkChecker struct {
+	MaxLinks       int
+	BlockedDomains []string
+}
+
+func (c *LinkChecker) Name() string { return "links" }
+
+func (c *LinkChecker) Check(ctx context.Context, in *SpamInput) (float64, error) {
+	hosts := spam.LinkHosts(in.Comment.CommentBody)
+	for _, host := range hosts {
+		for _, blocked := range c.BlockedDomains {
+			blocked = strings.ToLower(strings.TrimPrefix(blocked, "www."))
+			if host == blocked || strings.HasSuffix(host, "."+blocked) {
+				return 1, nil
+			}
+		}
+	}
+
+	links := len(spam.Links(in.Comment.CommentBody))
+	if links <= c.MaxLinks {
+		return 0, nil
+	}
+	// Just over the limit is suspicious; every further link more so.
+	return math.Min(1, 0.5+0.1*float64(links-c.MaxLinks-1)), nil
+}
+
+// HoneypotChecker flags submissions that filled in the hidden form field
+// only bots can see.
+type HoneypotChecker struct{}
+
+func (c *HoneypotChecker) Name() string { return "honeypot" }
+
+func (c *HoneypotChecker) Check(ctx context.Context, in *SpamInput) (float64, error) {
+	if strings.TrimSpace(in.Honeypot) != "" {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// SubmitTimeChecker flags comments submitted faster than a person could
+// have read the post and typed them. A submission without a valid form
+// token is half suspicious, since a browser showing the form would have
+// one.
+type SubmitTimeChecker struct {
+	MinDuration time.Duration
+}
+
+func (c *SubmitTimeChecker) Name() string { return "submit_time" }
+
+func (c *SubmitTimeChecker) Check(ctx context.Context, in *SpamInput) (float64, error) {
+	if in.Edit {
+		return 0, nil
+	}
+	if !in.FormTimed {
+		return 0.5, nil
+	}
+	if in.Elapsed >= c.MinDuration {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// tokenCommentForm is the purpose of the tokens that time comment forms.
+const tokenCommentForm = "comment-form"
+
+// commentFormTTL is how long a comment form token is accepted.
+const commentFormTTL = 24 * time.Hour
+
+// IssueCommentFormToken returns a token recording when the comment form for
+// a blog was shown. It goes back with the submitted comment so that the
+// submit time can't be made up by the client.
+func IssueCommentFormToken(blogID string) string {
+	now := time.Now()
+	subject := blogID + ":" + strconv.FormatInt(now.UnixMilli(), 10)
+	return tokens.Sign(linkSecret, tokenCommentForm, subject, now.Add(commentFormTTL))
+}
+
+// CommentFormElapsed returns how long ago the form token for a blog was
+// issued, or false if the token is missing, forged, expired or for another
+// blog.
+func CommentFormElapsed(token, blogID string) (time.Duration, bool) {
+	if token == "" {
+		return 0, false
+	}
+	subject, err := tokens.Verify(linkSecret, tokenCommentForm, token)
+	if err != nil {
+		return 0, false
+	}
+	id, issued, ok := strings.Cut(subject, ":")
+	if !ok || id != blogID {
+		return 0, false
+	}
+	millis, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Since(time.UnixMilli(millis)), true
+}
+
+// BayesChecker scores comments with the naive Bayes classifier trained on
+// moderator decisions. It stays silent until the classifier has enough
+// examples of both spam and ham.
+type BayesChecker struct{}
+
+func (c *BayesChecker) Name() string { return "bayes" }
+
+func (c *BayesChecker) Check(ctx context.Context, in *SpamInput) (float64, error) {
+	p, ok := spamClassifier.SpamProbability(spam.Tokenize(in.Comment.CommentBody))
+	if !ok {
+		return 0, nil
+	}
+	return p, nil
+}
+
+// TrainSpamClassifier teaches the classifier the most recent comments that
+// moderators marked as spam and the most recent ones they approved.
+// Comments published without review teach it nothing, so spam that gets
+// past the checks isn't learned as ham.
+func TrainSpamClassifier() error {
+	collection, ctx, cancel := GetCollectionAndContext("comments")
+	defer cancel()
+
+	examples := []struct {
+		status bson.M
+		class  spam.Class
+	}{
+		{bson.M{"$eq": models.CommentStatusSpam}, spam.Spam},
+		{bson.M{"$eq": models.CommentStatusApproved}, spam.Ham},
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(spamTrainingLimit).
+		SetProjection(bson.M{"comment_body": 1})
+	for _, example := range examples {
+		cursor, err := collection.Find(ctx, bson.M{"status": example.status, "moderatedAt": bson.M{"$ne": nil}, "placeholder": bson.M{"$ne": true}}, opts)
+		if err != nil {
+			return err
+		}
+		var comments []models.Comment
+		if err := cursor.All(ctx, &comments); err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			spamClassifier.Learn(spam.Tokenize(comment.CommentBody), example.class)
+		}
+	}
+	return nil
+}
+
+// learnModeration keeps the classifier in step with a moderation decision,
+// un-learning the label an earlier decision gave the comment.
+func learnModeration(comment *models.Comment, newStatus string) {
+	tokens := spam.Tokenize(comment.CommentBody)
+	if class, ok := spamClass(comment.Status); ok && comment.ModeratedAt != nil {
+		spamClassifier.Forget(tokens, class)
+	}
+	if class, ok := spamClass(newStatus); ok {
+		spamClassifier.Learn(tokens, class)
+	}
+}
+
+// spamClass maps a moderation status to the class the classifier learns it
+// as. Pending and rejected comments teach it nothing.
+func spamClass(status string) (spam.Class, bool) {
+	switch status {
+	case models.CommentStatusSpam:
+		return spam.Spam, true
+	case models.CommentStatusApproved:
+		return spam.Ham, true
+	}
+	return 0, false
+}
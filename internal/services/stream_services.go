@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"strings"
+
+	"goserver/internal/models"
+	"goserver/internal/realtime"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deletedCommentEvent is the payload of a comment.deleted event.
+type deletedCommentEvent struct {
+	ID     primitive.ObjectID `json:"_id"`
+	BlogID primitive.ObjectID `json:"blog_id"`
+}
+
+// isPublished reports whether readers can see comment.
+func isPublished(comment *models.Comment) bool {
+	return comment.Status == models.CommentStatusApproved || comment.Status == ""
+}
+
+// publishComment announces a created or updated comment on its blog's live
+// stream. Comments readers can't see are not announced, and the stream is
+// public, so the commenter's email is left out.
+func publishComment(eventType string, comment models.Comment) {
+	if !isPublished(&comment) {
+		return
+	}
+	comment.CommenterEmail = ""
+	comment.EditHistory = nil
+	comment.SpamScore = 0
+	comment.SpamReasons = nil
+	if err := realtime.Publish(realtime.BlogTopic(comment.BlogID.Hex()), eventType, comment); err != nil {
+		log.Printf("Failed to publish %s for comment %s: %v", eventType, comment.ID.Hex(), err)
+	}
+}
+
+// publishCommentDeleted announces that a comment is gone from its blog.
+func publishCommentDeleted(comment *models.Comment) {
+	event := deletedCommentEvent{ID: comment.ID, BlogID: comment.BlogID}
+	if err := realtime.Publish(realtime.BlogTopic(comment.BlogID.Hex()), realtime.EventCommentDeleted, event); err != nil {
+		log.Printf("Failed to publish comment.deleted for comment %s: %v", comment.ID.Hex(), err)
+	}
+}
+
+// publishPost announces a new post on the posts stream.
+func publishPost(blog *models.Blog) {
+	post := map[string]interface{}{
+		"_id":             blog.ID,
+		"blog_subject":    blog.Subject,
+		"blog_owner_name": blog.OwnerName,
+		"blog_category":   blog.Category,
+		"createdAt":       blog.CreatedAt,
+	}
+	if err := realtime.Publish(realtime.TopicPosts, realtime.EventPostCreated, post); err != nil {
+		log.Printf("Failed to publish post.created for blog %s: %v", blog.ID.Hex(), err)
+	}
+}
+
+// AuthorizeChannel decides what a WebSocket client may do on a channel. Any
+// signed-in user may follow new posts, and follow and be present on a live
+// blog's channel. Typing indicators need a role that may comment, and only
+// the author or an admin may show as editing the post.
+func AuthorizeChannel(client realtime.SocketClient, channel string) (realtime.ChannelAccess, error) {
+	if channel == realtime.TopicPosts {
+		return realtime.ChannelAccess{Topic: realtime.TopicPosts}, nil
+	}
+	blogID, ok := strings.CutPrefix(channel, "blog:")
+	if !ok {
+		return realtime.ChannelAccess{}, realtime.ErrUnknownChannel
+	}
+	blog, err := GetBlogByID(blogID)
+	if err != nil || blog == nil {
+		return realtime.ChannelAccess{}, realtime.ErrUnknownChannel
+	}
+
+	actor := CommentActor{UserID: client.UserID, UserName: client.UserName, Role: client.Role}
+	return realtime.ChannelAccess{
+		Topic:    realtime.BlogTopic(blog.ID.Hex()),
+		Presence: true,
+		Typing:   roleLevel(client.Role) >= models.USER_ROLES["COMMENTOR"].Level,
+		Editing:  actor.isAdmin() || IsBlogOwner(blog, client.UserID, client.UserName),
+	}, nil
+}
+
+// roleLevel returns the level of the named role, or 0 if there is none.
+func roleLevel(role string) int {
+	for _, r := range models.USER_ROLES {
+		if r.Name == role {
+			return r.Level
+		}
+	}
+	return 0
+}
@@ -0,0 +1,581 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"goserver/internal/models"
+	"goserver/internal/tokens"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUnknownSubscriptionScope = errors.New("scope must be one of all, category or author")
+	ErrUnknownDelivery          = errors.New("delivery must be one of immediate, daily or weekly")
+	ErrSubscriptionTarget       = errors.New("target must name an existing category or author")
+	ErrInvalidEmail             = errors.New("a valid email address is required")
+	ErrInvalidToken             = errors.New("this link is invalid or has expired")
+)
+
+// Purposes of the signed tokens in subscription emails.
+const (
+	tokenConfirmSubscription = "subscription-confirm"
+	tokenUnsubscribe         = "unsubscribe"
+)
+
+// subscriptionConfirmTTL is how long a confirmation link works.
+const subscriptionConfirmTTL = 7 * 24 * time.Hour
+
+// digestPeriods is how often each digest delivery is sent.
+var digestPeriods = map[string]time.Duration{
+	models.DeliveryDaily:  24 * time.Hour,
+	models.DeliveryWeekly: 7 * 24 * time.Hour,
+}
+
+// SubscriptionOptions configures the links in subscription emails.
+type SubscriptionOptions struct {
+	// APIURL is the public address of this API, which mail providers POST
+	// one-click unsubscribes to.
+	APIURL string
+}
+
+var subscriptionOpts SubscriptionOptions
+
+// ConfigureSubscriptions sets how subscription links are built.
+func ConfigureSubscriptions(opts SubscriptionOptions) {
+	subscriptionOpts = opts
+}
+
+// SubscriptionRequest is what a reader asks to be sent.
+type SubscriptionRequest struct {
+	Email    string
+	Scope    string
+	Target   string
+	Delivery string
+
+	// targetName is the author's user name when Target is their ID.
+	targetName string
+}
+
+// Subscribe signs a reader up for new-post emails. For a signed-in user the
+// subscription uses their address and is active straight away. Anyone else
+// is sent a confirmation email, and nothing else is sent to them until they
+// confirm. Subscribing again to the same thing changes the delivery.
+func Subscribe(req SubscriptionRequest, userID string) (*models.Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := normalizeSubscription(ctx, &req); err != nil {
+		return nil, err
+	}
+
+	var owner *primitive.ObjectID
+	if userID != "" {
+		user, err := GetUserByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.UserEmail == "" {
+			return nil, ErrInvalidEmail
+		}
+		req.Email = strings.ToLower(user.UserEmail)
+		owner = &user.ID
+	}
+
+	collection := getCollection("subscriptions")
+	now := time.Now()
+	filter := bson.M{"email": req.Email, "scope": req.Scope, "target": req.Target}
+
+	var existing models.Subscription
+	err := collection.FindOne(ctx, filter).Decode(&existing)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		sub := models.Subscription{
+			ID:         primitive.NewObjectID(),
+			UserID:     owner,
+			Email:      req.Email,
+			Scope:      req.Scope,
+			Target:     req.Target,
+			TargetName: req.targetName,
+			Delivery:   req.Delivery,
+			Confirmed:  owner != nil,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if sub.Confirmed {
+			sub.ConfirmedAt = &now
+			sub.LastDigestAt = &now
+		}
+		if _, err := collection.InsertOne(ctx, sub); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				// Subscribed twice at once; update the one that won.
+				return Subscribe(req, userID)
+			}
+			return nil, err
+		}
+		if !sub.Confirmed {
+			go sendSubscriptionConfirmation(sub)
+		}
+		return &sub, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// Only the owner of an address may change an active subscription.
+	if existing.Confirmed && owner == nil {
+		return &existing, nil
+	}
+
+	set := bson.M{"delivery": req.Delivery, "updatedAt": now}
+	if req.targetName != "" {
+		set["target_name"] = req.targetName
+	}
+	if existing.Delivery != req.Delivery {
+		set["lastDigestAt"] = now
+	}
+	if owner != nil {
+		set["user_id"] = owner
+		if !existing.Confirmed {
+			set["confirmed"] = true
+			set["confirmedAt"] = now
+			set["lastDigestAt"] = now
+		}
+	}
+	var updated models.Subscription
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+	if !updated.Confirmed {
+		go sendSubscriptionConfirmation(updated)
+	}
+	return &updated, nil
+}
+
+// normalizeSubscription validates a request and fills in its defaults.
+func normalizeSubscription(ctx context.Context, req *SubscriptionRequest) error {
+	if req.Scope == "" {
+		req.Scope = models.SubscriptionScopeAll
+	}
+	if req.Delivery == "" {
+		req.Delivery = models.DeliveryImmediate
+	}
+	if req.Delivery != models.DeliveryImmediate && digestPeriods[req.Delivery] == 0 {
+		return ErrUnknownDelivery
+	}
+
+	req.Target = strings.TrimSpace(req.Target)
+	switch req.Scope {
+	case models.SubscriptionScopeAll:
+		req.Target = ""
+	case models.SubscriptionScopeCategory:
+		if req.Target == "" {
+			return ErrSubscriptionTarget
+		}
+		// Categories only exist as long as some post is filed under them
+		err := getCollection("blogs").FindOne(ctx, notDeleted(bson.M{"blog_category": req.Target}),
+			options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if err == mongo.ErrNoDocuments {
+			return ErrSubscriptionTarget
+		}
+		if err != nil {
+			return err
+		}
+	case models.SubscriptionScopeAuthor:
+		author, err := findUserByName(ctx, req.Target)
+		if err != nil {
+			return err
+		}
+		if author == nil {
+			return ErrSubscriptionTarget
+		}
+		// Subscribers follow the account, whatever it is called later
+		req.Target = author.ID.Hex()
+		req.targetName = author.UserName
+	default:
+		return ErrUnknownSubscriptionScope
+	}
+
+	if req.Email != "" {
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			return ErrInvalidEmail
+		}
+		req.Email = strings.ToLower(addr.Address)
+	}
+	return nil
+}
+
+// ConfirmSubscription activates the subscription a confirmation link was
+// sent for.
+func ConfirmSubscription(token string) (*models.Subscription, error) {
+	id, err := tokens.Verify(linkSecret, tokenConfirmSubscription, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("subscriptions")
+	defer cancel()
+
+	var sub models.Subscription
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&sub); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if sub.Confirmed {
+		return &sub, nil
+	}
+
+	now := time.Now()
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"confirmed":    true,
+		"confirmedAt":  now,
+		"lastDigestAt": now,
+		"updatedAt":    now,
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&sub)
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// Unsubscribe deletes the subscription an unsubscribe link was sent for.
+// Following a link for a subscription that is already gone succeeds.
+func Unsubscribe(token string) error {
+	id, err := tokens.Verify(linkSecret, tokenUnsubscribe, token)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("subscriptions")
+	defer cancel()
+	_, err = collection.DeleteOne(ctx, bson.M{"_id": objID})
+	return err
+}
+
+// ownSubscriptions matches the subscriptions that belong to user, including
+// ones made for their address before they signed in.
+func ownSubscriptions(user *models.User) bson.M {
+	or := bson.A{bson.M{"user_id": user.ID}}
+	if user.UserEmail != "" {
+		or = append(or, bson.M{"email": strings.ToLower(user.UserEmail)})
+	}
+	return bson.M{"$or": or}
+}
+
+func subscriptionOwner(userID string) (*models.User, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return user, nil
+}
+
+// GetSubscriptions lists a user's subscriptions.
+func GetSubscriptions(userID string) ([]models.Subscription, error) {
+	user, err := subscriptionOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("subscriptions")
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, ownSubscriptions(user), options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	subs := []models.Subscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// UpdateSubscriptionDelivery changes how one of a user's subscriptions is
+// delivered. A digest starts from the time of the change.
+func UpdateSubscriptionDelivery(userID, id, delivery string) (*models.Subscription, error) {
+	if delivery != models.DeliveryImmediate && digestPeriods[delivery] == 0 {
+		return nil, ErrUnknownDelivery
+	}
+	user, err := subscriptionOwner(userID)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("subscriptions")
+	defer cancel()
+
+	filter := ownSubscriptions(user)
+	filter["_id"] = objID
+	filter["delivery"] = bson.M{"$ne": delivery}
+	now := time.Now()
+	_, err = collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"delivery": delivery, "lastDigestAt": now, "updatedAt": now}})
+	if err != nil {
+		return nil, err
+	}
+
+	delete(filter, "delivery")
+	var sub models.Subscription
+	if err := collection.FindOne(ctx, filter).Decode(&sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// DeleteSubscription removes one of a user's subscriptions.
+func DeleteSubscription(userID, id string) error {
+	user, err := subscriptionOwner(userID)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("subscriptions")
+	defer cancel()
+
+	filter := ownSubscriptions(user)
+	filter["_id"] = objID
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// subscriptionLinks returns the unsubscribe link for the body of an email
+// about sub and the RFC 8058 headers for one-click unsubscribing. The body
+// link opens a page on the frontend, because mail scanners follow GET links.
+func subscriptionLinks(sub *models.Subscription) (string, map[string]string) {
+	token := url.QueryEscape(tokens.Sign(linkSecret, tokenUnsubscribe, sub.ID.Hex(), time.Time{}))
+	oneClick := strings.TrimRight(subscriptionOpts.APIURL, "/") + "/api/v1/subscriptions/unsubscribe?token=" + token
+	return frontendURL("/unsubscribe?token=" + token), map[string]string{
+		"List-Unsubscribe":      "<" + oneClick + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+func sendSubscriptionConfirmation(sub models.Subscription) {
+	token := tokens.Sign(linkSecret, tokenConfirmSubscription, sub.ID.Hex(), time.Now().Add(subscriptionConfirmTTL))
+	confirmURL := frontendURL("/subscriptions/confirm?token=" + url.QueryEscape(token))
+	if err := SendSubscriptionConfirmation(&sub, confirmURL); err != nil {
+		log.Printf("Error sending subscription confirmation: %v", err)
+	}
+}
+
+// subscribedTo matches the subscriptions that cover blog, which was written
+// by the given account, or none if it isn't known.
+func subscribedTo(blog *models.Blog, author *models.User) bson.A {
+	or := bson.A{
+		bson.M{"scope": models.SubscriptionScopeAll},
+		bson.M{"scope": models.SubscriptionScopeCategory, "target": blog.Category},
+	}
+	if author != nil {
+		or = append(or, bson.M{"scope": models.SubscriptionScopeAuthor, "target": author.ID.Hex()})
+	}
+	return or
+}
+
+// notifySubscribers emails a new post to its immediate subscribers. Each
+// address gets one email however many of its subscriptions match.
+func notifySubscribers(blog models.Blog) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	author, err := blogAuthor(ctx, &blog)
+	if err != nil {
+		log.Printf("Error finding the author of blog %s: %v", blog.ID.Hex(), err)
+	}
+	cursor, err := getCollection("subscriptions").Find(ctx, bson.M{
+		"confirmed": true,
+		"delivery":  models.DeliveryImmediate,
+		"$or":       subscribedTo(&blog, author),
+	})
+	if err != nil {
+		log.Printf("Error finding subscribers: %v", err)
+		return
+	}
+	var subs []models.Subscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		log.Printf("Error finding subscribers: %v", err)
+		return
+	}
+
+	sent := map[string]bool{}
+	for i := range subs {
+		sub := &subs[i]
+		if sent[sub.Email] || strings.EqualFold(sub.Email, blog.OwnerEmail) {
+			continue
+		}
+		sent[sub.Email] = true
+		SendBlogNotification(sub, &blog)
+	}
+}
+
+// StartDigestScheduler sends daily and weekly digests, checking for
+// subscriptions that are due every interval.
+func StartDigestScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := SendDueDigests(); err != nil {
+				log.Printf("Error sending digests: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// SendDueDigests sends a digest for each subscription whose period has
+// passed since its last one. A subscription with no new posts gets no
+// email, but its period still starts again.
+func SendDueDigests() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	now := time.Now()
+	for delivery, period := range digestPeriods {
+		cursor, err := getCollection("subscriptions").Find(ctx, bson.M{
+			"confirmed":    true,
+			"delivery":     delivery,
+			"lastDigestAt": bson.M{"$lte": now.Add(-period)},
+		})
+		if err != nil {
+			return err
+		}
+		var subs []models.Subscription
+		if err := cursor.All(ctx, &subs); err != nil {
+			return err
+		}
+		for i := range subs {
+			if err := sendDigest(ctx, &subs[i], now); err != nil {
+				log.Printf("Error sending digest for subscription %s: %v", subs[i].ID.Hex(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// digestPostLimit caps how many posts one digest lists.
+const digestPostLimit = 50
+
+func sendDigest(ctx context.Context, sub *models.Subscription, now time.Time) error {
+	since := *sub.LastDigestAt
+
+	// Claim the period first so that two instances never both send it.
+	res, err := getCollection("subscriptions").UpdateOne(ctx,
+		bson.M{"_id": sub.ID, "lastDigestAt": since},
+		bson.M{"$set": bson.M{"lastDigestAt": now}})
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+
+	filter := notDeleted(bson.M{"createdAt": bson.M{"$gt": since, "$lte": now}})
+	switch sub.Scope {
+	case models.SubscriptionScopeCategory:
+		filter["blog_category"] = sub.Target
+	case models.SubscriptionScopeAuthor:
+		// A name nobody has any more follows no one
+		if _, err := primitive.ObjectIDFromHex(sub.Target); err != nil {
+			return nil
+		}
+		// Posts from before accounts were linked go by the author's
+		// current user name
+		author, err := GetUserByID(sub.Target)
+		if err != nil {
+			return err
+		}
+		userName := ""
+		if author != nil {
+			userName = author.UserName
+		}
+		filter["$or"] = blogsByAuthor(sub.Target, userName)["$or"]
+	}
+	cursor, err := getCollection("blogs").Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetLimit(digestPostLimit).
+		SetProjection(bson.M{"blog_subject": 1, "blog_owner_name": 1, "blog_owner_email": 1, "createdAt": 1}))
+	if err != nil {
+		return err
+	}
+	var posts []models.Blog
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+	return SendDigestEmail(sub, posts, since)
+}
+
+// MigrateAuthorSubscriptions points author subscriptions made before they
+// were kept by user ID at the author's account. Subscriptions to names that
+// no longer belong to anyone are left as they are and match nothing.
+func MigrateAuthorSubscriptions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	collection := getCollection("subscriptions")
+	cursor, err := collection.Find(ctx, bson.M{
+		"scope":       models.SubscriptionScopeAuthor,
+		"target_name": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return err
+	}
+	var subs []models.Subscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		author, err := findUserByName(ctx, sub.Target)
+		if err != nil {
+			return err
+		}
+		if author == nil {
+			continue
+		}
+		_, err = collection.UpdateOne(ctx, bson.M{"_id": sub.ID}, bson.M{"$set": bson.M{
+			"target":      author.ID.Hex(),
+			"target_name": author.UserName,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
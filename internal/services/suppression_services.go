@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"goserver/internal/mailer"
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrEmailSuppressed = errors.New("address is on the suppression list")
+
+// RecordDeliveryEvents handles the delivery events a mail provider reported.
+// Hard bounces and complaints suppress the address and flag the users who
+// have it, so that the UI can ask them for a new one.
+func RecordDeliveryEvents(events []mailer.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, event := range events {
+		if event.Email == "" || !event.Suppresses() {
+			continue
+		}
+		if err := suppressEmail(ctx, event); err != nil {
+			return err
+		}
+		log.Printf("Suppressed %s after %s from %s: %s", event.Email, event.Type, event.Provider, event.Reason)
+	}
+	return nil
+}
+
+func suppressEmail(ctx context.Context, event mailer.Event) error {
+	email := bareAddress(event.Email)
+	now := time.Now()
+	_, err := getCollection("email_suppressions").UpdateOne(ctx,
+		bson.M{"email": email},
+		bson.M{
+			"$set": bson.M{
+				"type":      event.Type,
+				"reason":    event.Reason,
+				"provider":  event.Provider,
+				"updatedAt": now,
+			},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	_, err = getCollection("users").UpdateMany(ctx, usersWithEmail(email),
+		bson.M{"$set": bson.M{"email_undeliverable": true, "updatedAt": now}})
+	return err
+}
+
+// usersWithEmail matches the users with the address. Addresses are stored
+// lower-cased, so this ignores case.
+func usersWithEmail(email string) bson.M {
+	return bson.M{"user_email": normalizeEmail(email)}
+}
+
+// IsEmailSuppressed reports whether email must not be sent to the address.
+func IsEmailSuppressed(email string) (bool, error) {
+	collection, ctx, cancel := GetCollectionAndContext("email_suppressions")
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"email": bareAddress(email)}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// bareAddress strips any display name from an email address and lower-cases
+// it, the way suppressed addresses are stored.
+func bareAddress(email string) string {
+	if addr, err := mail.ParseAddress(email); err == nil {
+		email = addr.Address
+	}
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// GetSuppressions lists suppressed addresses, most recent first.
+func GetSuppressions(limit, skip int64) ([]models.EmailSuppression, error) {
+	collection, ctx, cancel := GetCollectionAndContext("email_suppressions")
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	suppressions := []models.EmailSuppression{}
+	if err := cursor.All(ctx, &suppressions); err != nil {
+		return nil, err
+	}
+	return suppressions, nil
+}
+
+// RemoveSuppression lets email be sent to an address again, e.g. after its
+// owner fixed their mailbox, and clears the flag on their account.
+func RemoveSuppression(email string) error {
+	email = bareAddress(email)
+
+	collection, ctx, cancel := GetCollectionAndContext("email_suppressions")
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = getCollection("users").UpdateMany(ctx, usersWithEmail(email),
+		bson.M{"$unset": bson.M{"email_undeliverable": ""}, "$set": bson.M{"updatedAt": time.Now()}})
+	return err
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"goserver/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// trashCollections are the collections that support soft delete.
+var trashCollections = []string{"blogs", "comments", "users"}
+
+// notDeleted adds the condition that excludes trashed documents to a filter.
+func notDeleted(filter bson.M) bson.M {
+	filter["deletedAt"] = nil
+	return filter
+}
+
+// restoreDocument takes a document out of the trash.
+func restoreDocument(collectionName, id string) error {
+	collection, ctx, cancel := GetCollectionAndContext(collectionName)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "deletedAt": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// findTrashed decodes every trashed document in a collection into results,
+// most recently deleted first.
+func findTrashed(collectionName string, results interface{}) error {
+	collection, ctx, cancel := GetCollectionAndContext(collectionName)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"deletedAt": bson.M{"$ne": nil}}, opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// GetTrashedBlogs lists blogs in the trash
+func GetTrashedBlogs() ([]models.Blog, error) {
+	blogs := []models.Blog{}
+	err := findTrashed("blogs", &blogs)
+	return blogs, err
+}
+
+// GetTrashedComments lists comments in the trash
+func GetTrashedComments() ([]models.Comment, error) {
+	comments := []models.Comment{}
+	err := findTrashed("comments", &comments)
+	return comments, err
+}
+
+// GetTrashedUsers lists users in the trash
+func GetTrashedUsers() ([]models.User, error) {
+	users := []models.User{}
+	err := findTrashed("users", &users)
+	return users, err
+}
+
+// RestoreBlog takes a blog out of the trash together with the comments
+// that were trashed along with it
+func RestoreBlog(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	err = withTransaction(func(ctx context.Context) error {
+		return restoreBlogWithComments(ctx, objID)
+	})
+	if err != nil {
+		return err
+	}
+	InvalidateSitemap()
+	return nil
+}
+
+// RestoreComment takes a comment out of the trash
+func RestoreComment(id string) error {
+	if err := restoreDocument("comments", id); err != nil {
+		return err
+	}
+
+	collection, ctx, cancel := GetCollectionAndContext("comments")
+	defer cancel()
+
+	objID, _ := primitive.ObjectIDFromHex(id)
+	var comment models.Comment
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&comment); err != nil {
+		return err
+	}
+	return refreshCommentCounts(ctx, comment.BlogID)
+}
+
+// RestoreUser takes a user out of the trash together with the posts and
+// comments that were trashed along with them. A user whose content was
+// reassigned or anonymized can't be restored, since that content no longer
+// says who wrote it.
+func RestoreUser(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	err = withTransaction(func(ctx context.Context) error {
+		users := getCollection("users")
+		var user models.User
+		err := users.FindOne(ctx, bson.M{"_id": objID, "deletedAt": bson.M{"$ne": nil}}).Decode(&user)
+		if err != nil {
+			return err
+		}
+		if user.DeletedContent == UserContentReassign || user.DeletedContent == UserContentAnonymize {
+			return ErrContentHandedOver
+		}
+
+		_, err = users.UpdateOne(ctx, bson.M{"_id": objID},
+			bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": "", "deletedContent": ""}})
+		if err != nil {
+			return err
+		}
+		return restoreTrashedWith(ctx, *user.DeletedAt)
+	})
+	if err != nil {
+		return err
+	}
+	InvalidateSitemap()
+	return nil
+}
+
+// restoreTrashedWith restores the blogs and comments that were trashed at
+// the same moment as something else, such as their author.
+func restoreTrashedWith(ctx context.Context, at time.Time) error {
+	cursor, err := getCollection("blogs").Find(ctx, bson.M{"deletedAt": at})
+	if err != nil {
+		return err
+	}
+	var blogs []models.Blog
+	if err := cursor.All(ctx, &blogs); err != nil {
+		return err
+	}
+	for _, blog := range blogs {
+		if err := restoreBlogWithComments(ctx, blog.ID); err != nil {
+			return err
+		}
+	}
+
+	comments := getCollection("comments")
+	blogIDs, err := comments.Distinct(ctx, "blog_id", bson.M{"deletedAt": at})
+	if err != nil {
+		return err
+	}
+	_, err = comments.UpdateMany(ctx, bson.M{"deletedAt": at},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}})
+	if err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, 0, len(blogIDs))
+	for _, id := range blogIDs {
+		if objID, ok := id.(primitive.ObjectID); ok {
+			ids = append(ids, objID)
+		}
+	}
+	return refreshCommentCounts(ctx, ids...)
+}
+
+// PurgeTrash permanently removes documents that have been in the trash for
+// longer than the retention period.
+func PurgeTrash(retention time.Duration) error {
+	cutoff := time.Now().Add(-retention)
+	for _, name := range trashCollections {
+		collection, ctx, cancel := GetCollectionAndContext(name)
+		result, err := collection.DeleteMany(ctx, bson.M{"deletedAt": bson.M{"$lt": cutoff}})
+		cancel()
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount > 0 {
+			log.Printf("Purged %d trashed documents from %s", result.DeletedCount, name)
+		}
+	}
+	return nil
+}
+
+// StartTrashPurger runs PurgeTrash every interval in the background.
+func StartTrashPurger(retention, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := PurgeTrash(retention); err != nil {
+				log.Printf("Error purging trash: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/mail"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"goserver/internal/database"
 	"goserver/internal/models"
@@ -13,9 +16,78 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrEmailCollision = errors.New("several accounts share an email address that differs only in case; merge them or change all but one")
+
+// emailCollation compares addresses without regard to case. The unique
+// index on user_email uses it, so queries that should use that index must
+// too.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// normalizeEmail is how user email addresses are stored: trimmed and lower
+// case.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailConflict reports whether err is the unique index on user_email
+// refusing a write.
+func emailConflict(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "user_email")
+}
+
+// MigrateUserEmails lower-cases the addresses of users saved before
+// addresses were normalized. Run it before EnsureIndexes so that the unique
+// index can be built. Accounts whose addresses differ only in case are left
+// alone and reported, since the index can't be built until someone merges
+// them or changes one of the addresses.
+func MigrateUserEmails() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := getCollection("users")
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$user_email"}}}
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_email": bson.M{"$type": "string"}}}},
+		{{Key: "$group", Value: bson.M{"_id": normalized, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var collisions []struct {
+		Email string               `bson:"_id"`
+		IDs   []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &collisions); err != nil {
+		return err
+	}
+	colliding := bson.A{}
+	var report []string
+	for _, collision := range collisions {
+		ids := make([]string, 0, len(collision.IDs))
+		for _, id := range collision.IDs {
+			colliding = append(colliding, id)
+			ids = append(ids, id.Hex())
+		}
+		report = append(report, fmt.Sprintf("%s (users %s)", collision.Email, strings.Join(ids, ", ")))
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"user_email": bson.M{"$regex": `[A-Z]|^\s|\s$`}, "_id": bson.M{"$nin": colliding}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"user_email": normalized}}}})
+	if err != nil {
+		return err
+	}
+	if len(report) > 0 {
+		return fmt.Errorf("%w: %s", ErrEmailCollision, strings.Join(report, "; "))
+	}
+	return nil
+}
+
 func getUsersCollectionAndContext() (*mongo.Collection, context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	collection := database.MongoClient.Database("edandlinda").Collection("users")
@@ -32,7 +104,7 @@ func GetUserByID(id string) (*models.User, error) {
 	}
 
 	var user models.User
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
+	err = collection.FindOne(ctx, notDeleted(bson.M{"_id": objectID})).Decode(&user)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -47,7 +119,7 @@ func GetAllUsers() ([]models.User, error) {
 	collection, ctx, cancel := getUsersCollectionAndContext()
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{})
+	cursor, err := collection.Find(ctx, notDeleted(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -69,44 +141,84 @@ func GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func CreateUser(user *models.User) error {
+// CreateUser signs up a new user with a name, email address and password.
+// Sign-ups always start at the lowest role and must verify their email;
+// other roles come from an admin or an invitation, and profile details are
+// filled in afterwards.
+func CreateUser(userName, email, password string) (*models.User, error) {
+	userName = strings.TrimSpace(userName)
+	if userName == "" {
+		return nil, ErrUserNameRequired
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	user := models.User{
+		UserName:     userName,
+		UserEmail:    addr.Address,
+		UserPassword: password,
+		Role:         models.USER_ROLES["USER"].Name,
+	}
+	if err := insertUser(&user, false); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// insertUser checks that the user's email and name are free, hashes their
+// password and saves them. A verified user can log in straight away;
+// anyone else is given a verification code.
+func insertUser(user *models.User, verified bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	collection := database.MongoClient.Database("edandlinda").Collection("users")
 
 	// Check if email already exists
+	user.UserEmail = normalizeEmail(user.UserEmail)
 	if user.UserEmail == "" {
 		return errors.New("email is required")
 	}
 
-	var existingUser models.User
-	err := collection.FindOne(ctx, bson.M{"user_email": user.UserEmail}).Decode(&existingUser)
-	if err == nil {
-		return errors.New("email already in use")
-	} else if err != mongo.ErrNoDocuments {
+	taken, err := emailTaken(ctx, user.UserEmail, primitive.NilObjectID)
+	if err != nil {
 		return fmt.Errorf("error checking email: %v", err)
 	}
+	if taken {
+		return ErrEmailInUse
+	}
 
 	// Check if username already exists
+	var existingUser models.User
 	err = collection.FindOne(ctx, bson.M{"user_name": user.UserName}).Decode(&existingUser)
 	if err == nil {
-		return errors.New("username already in use")
+		return ErrUserNameInUse
 	} else if err != mongo.ErrNoDocuments {
 		return fmt.Errorf("error checking username: %v", err)
 	}
 
-	// Generate verification code
-	user.UserVerifyCode = uuid.New().String()
-	user.UserApproved = false // Default to false until verified
-
-	// Set default role if not provided
-	if user.Role == "" {
-		user.Role = models.USER_ROLES["USER"].Name
+	user.EmailUndeliverable, err = IsEmailSuppressed(user.UserEmail)
+	if err != nil {
+		return fmt.Errorf("error checking email: %v", err)
 	}
 
-	// Set verify expiration (24 hours from now)
-	user.UserVerifyExpires = time.Now().Add(24 * time.Hour)
+	if verified {
+		user.UserVerifyCode = ""
+		user.UserVerifyExpires = time.Time{}
+		user.UserApproved = true
+	} else {
+		// Generate verification code
+		user.UserVerifyCode = uuid.New().String()
+		user.UserApproved = false // Default to false until verified
+
+		// Set verify expiration (24 hours from now)
+		user.UserVerifyExpires = time.Now().Add(24 * time.Hour)
+	}
 
 	// Hash password
 	salt, err := bcrypt.GenerateFromPassword([]byte(user.UserPassword), bcrypt.DefaultCost)
@@ -122,6 +234,10 @@ func CreateUser(user *models.User) error {
 	// Insert user
 	result, err := collection.InsertOne(ctx, user)
 	if err != nil {
+		// Someone else took the address since it was checked
+		if emailConflict(err) {
+			return ErrEmailInUse
+		}
 		// Check for MongoDB duplicate key error
 		if mongo.IsDuplicateKeyError(err) {
 			return errors.New("duplicate key error")
@@ -138,7 +254,10 @@ func CreateUser(user *models.User) error {
 	return nil
 }
 
-func UpdateUser(id string, user *models.User) error {
+// UpdateUser applies an admin's changes to a user. Changing the email
+// address skips confirmation, so it is checked for uniqueness and recorded
+// in the audit log under actorID.
+func UpdateUser(id, actorID string, user *models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -166,8 +285,34 @@ func UpdateUser(id string, user *models.User) error {
 	if user.UserName != "" {
 		updateDoc["user_name"] = user.UserName
 	}
+	var oldEmail string
+	user.UserEmail = normalizeEmail(user.UserEmail)
 	if user.UserEmail != "" {
+		var existing models.User
+		err := collection.FindOne(ctx, notDeleted(bson.M{"_id": objectID})).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			return errors.New("user not found")
+		} else if err != nil {
+			return fmt.Errorf("error checking email: %v", err)
+		}
+		if existing.UserEmail != user.UserEmail {
+			taken, err := emailTaken(ctx, user.UserEmail, objectID)
+			if err != nil {
+				return fmt.Errorf("error checking email: %v", err)
+			}
+			if taken {
+				return ErrEmailInUse
+			}
+			oldEmail = existing.UserEmail
+		}
+
 		updateDoc["user_email"] = user.UserEmail
+		// A new address starts out deliverable unless it is suppressed too
+		suppressed, err := IsEmailSuppressed(user.UserEmail)
+		if err != nil {
+			return fmt.Errorf("error checking email: %v", err)
+		}
+		updateDoc["email_undeliverable"] = suppressed
 	}
 	if user.Role != "" {
 		updateDoc["role"] = user.Role
@@ -181,10 +326,13 @@ func UpdateUser(id string, user *models.User) error {
 	// Perform update
 	result, err := collection.UpdateOne(
 		ctx,
-		bson.M{"_id": objectID},
+		notDeleted(bson.M{"_id": objectID}),
 		bson.M{"$set": updateDoc},
 	)
 
+	if emailConflict(err) {
+		return ErrEmailInUse
+	}
 	if err != nil {
 		return fmt.Errorf("error updating user: %v", err)
 	}
@@ -193,32 +341,66 @@ func UpdateUser(id string, user *models.User) error {
 		return errors.New("user not found")
 	}
 
+	if oldEmail != "" {
+		// The override makes any pending change by the user moot
+		getCollection("email_changes").DeleteMany(ctx, bson.M{"user_id": objectID})
+		moveSubscriptions(ctx, objectID, user.UserEmail)
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditEmailChangedAdmin,
+			ActorID:    actorID,
+			TargetType: "user",
+			TargetID:   id,
+			Details:    map[string]string{"old_email": oldEmail, "new_email": user.UserEmail},
+		})
+	}
+
 	fmt.Printf("Updated User: %s\n", user.UserName)
 	return nil
 }
 
-func DeleteUser(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	collection := database.MongoClient.Database("edandlinda").Collection("users")
-
+// DeleteUser moves a user to the trash and reassigns, anonymizes or trashes
+// their posts and comments as selected by opts.
+func DeleteUser(id, deletedBy string, opts DeleteUserOptions) (*CascadeResult, error) {
 	// Convert string ID to ObjectID
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return fmt.Errorf("invalid user ID: %v", err)
+		return nil, fmt.Errorf("invalid user ID: %v", err)
 	}
 
-	// Delete the user
-	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	if err != nil {
-		return fmt.Errorf("error deleting user: %v", err)
-	}
+	var result CascadeResult
+	err = withTransaction(func(ctx context.Context) error {
+		result = CascadeResult{}
+		collection := getCollection("users")
 
-	if result.DeletedCount == 0 {
-		return errors.New("user not found")
+		var user models.User
+		err := collection.FindOne(ctx, notDeleted(bson.M{"_id": objectID})).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return errors.New("user not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := handOverUserContent(ctx, &user, deletedBy, opts, now, &result); err != nil {
+			return err
+		}
+
+		// Move the user to the trash, remembering what happened to their
+		// content so that a restore knows whether it can bring it back
+		res, err := collection.UpdateOne(ctx, notDeleted(bson.M{"_id": objectID}),
+			bson.M{"$set": bson.M{"deletedAt": now, "deletedBy": deletedBy, "deletedContent": opts.Content}})
+		if err != nil {
+			return fmt.Errorf("error deleting user: %v", err)
+		}
+		result.Users = res.ModifiedCount
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	InvalidateSitemap()
 	fmt.Printf("Deleted user with ID: %s\n", id)
-	return nil
+	return &result, nil
 }
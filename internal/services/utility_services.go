@@ -9,9 +9,45 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// getCollection returns a MongoDB collection for use with a caller-supplied context.
+func getCollection(collectionName string) *mongo.Collection {
+	return database.MongoClient.Database("edandlinda").Collection(collectionName)
+}
+
+// withTransaction runs fn inside a multi-document transaction when the
+// deployment supports one, and directly otherwise. fn may be retried on
+// transient errors, so it must not accumulate state across calls.
+func withTransaction(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if !database.SupportsTransactions {
+		return fn(ctx)
+	}
+
+	session, err := database.MongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
 // GetCollectionAndContext returns a MongoDB collection, context, and cancel function for a given collection name.
 func GetCollectionAndContext(collectionName string) (*mongo.Collection, context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	collection := database.MongoClient.Database("edandlinda").Collection(collectionName)
 	return collection, ctx, cancel
 }
+
+// linkSecret signs the tokens in links sent by email.
+var linkSecret string
+
+// SetLinkSecret sets the secret that signs the tokens in emailed links.
+func SetLinkSecret(secret string) {
+	linkSecret = secret
+}
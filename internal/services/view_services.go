@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dayFormat is the layout of the per-day keys in the analytics collections.
+const dayFormat = "2006-01-02"
+
+// directReferrer is recorded for views without a usable Referer header.
+const directReferrer = "(direct)"
+
+// botSignatures are lower-case user agent fragments of crawlers, link
+// previewers and scripted clients whose requests are not counted as views.
+var botSignatures = []string{
+	"bot", "crawler", "spider", "slurp", "crawl", "archiver", "curl", "wget",
+	"python-requests", "go-http-client", "httpclient", "headless", "phantomjs",
+	"facebookexternalhit", "embedly", "preview", "monitor", "pingdom", "lighthouse",
+}
+
+type viewKey struct {
+	blogID primitive.ObjectID
+	day    string
+}
+
+type pendingViews struct {
+	views     int64
+	referrers map[string]int64
+}
+
+// Visitors already counted today are remembered in a Bloom filter of
+// seenBits bits, set seenHashes times per visitor. Its size is fixed, so
+// varying the User-Agent can't grow it; the price is that once a day's
+// visitors number in the millions a few first views go uncounted.
+const (
+	seenBits   = 1 << 24
+	seenHashes = 4
+)
+
+// seenFilter is a Bloom filter of visitor hashes.
+type seenFilter []uint64
+
+// add records a visitor hash and reports whether it was already there,
+// or collided with ones that were.
+func (f seenFilter) add(visitor []byte) bool {
+	seen := true
+	for i := 0; i < seenHashes; i++ {
+		bit := binary.BigEndian.Uint32(visitor[i*4:]) % seenBits
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f[word]&mask == 0 {
+			seen = false
+			f[word] |= mask
+		}
+	}
+	return seen
+}
+
+// viewTracker de-duplicates and buffers post views in memory. Visitors are
+// identified only by a salted hash that changes every day, so nothing
+// stored can be linked back to an IP address.
+var viewTracker = struct {
+	sync.Mutex
+	day     string
+	salt    []byte
+	seen    seenFilter
+	pending map[viewKey]*pendingViews
+}{
+	pending: map[viewKey]*pendingViews{},
+}
+
+// IsBot reports whether a user agent looks like an automated client.
+func IsBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return true
+	}
+	for _, signature := range botSignatures {
+		if strings.Contains(ua, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordView counts a view of a blog post unless it comes from a bot or the
+// same visitor already viewed the post today. Counts are kept in memory
+// until the next FlushViews.
+func RecordView(blogID primitive.ObjectID, clientIP, userAgent, referer string) {
+	if IsBot(userAgent) {
+		return
+	}
+
+	now := time.Now().UTC()
+	day := now.Format(dayFormat)
+
+	viewTracker.Lock()
+	defer viewTracker.Unlock()
+
+	if viewTracker.day != day {
+		viewTracker.day = day
+		viewTracker.seen = make(seenFilter, seenBits/64)
+		viewTracker.salt = make([]byte, 32)
+		if _, err := rand.Read(viewTracker.salt); err != nil {
+			log.Printf("Error generating view salt: %v", err)
+		}
+	}
+
+	sum := sha256.New()
+	sum.Write(viewTracker.salt)
+	sum.Write([]byte(clientIP + "|" + userAgent + "|" + blogID.Hex()))
+	if viewTracker.seen.add(sum.Sum(nil)) {
+		return
+	}
+
+	key := viewKey{blogID: blogID, day: day}
+	entry, ok := viewTracker.pending[key]
+	if !ok {
+		entry = &pendingViews{referrers: map[string]int64{}}
+		viewTracker.pending[key] = entry
+	}
+	entry.views++
+	entry.referrers[referrerHost(referer)]++
+}
+
+func referrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return directReferrer
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// viewBatch is a set of view counts written to MongoDB in one flush. Each
+// document a batch touches remembers the batch's ID, so that a batch that
+// failed part way through can be retried without counting any view twice.
+type viewBatch struct {
+	id      string
+	pending map[viewKey]*pendingViews
+}
+
+// viewBatchMemory is how many recent batch IDs each document remembers.
+const viewBatchMemory = 20
+
+// failedViewBatches are batches waiting to be retried, oldest first.
+var failedViewBatches struct {
+	sync.Mutex
+	batches []viewBatch
+}
+
+// FlushViews writes the buffered view counts to MongoDB. Batches that fail
+// are kept and retried as they were on the next flush.
+func FlushViews() error {
+	viewTracker.Lock()
+	pending := viewTracker.pending
+	viewTracker.pending = map[viewKey]*pendingViews{}
+	viewTracker.Unlock()
+
+	failedViewBatches.Lock()
+	defer failedViewBatches.Unlock()
+
+	batches := failedViewBatches.batches
+	if len(pending) > 0 {
+		batches = append(batches, viewBatch{id: primitive.NewObjectID().Hex(), pending: pending})
+	}
+
+	for i, batch := range batches {
+		if err := writeViews(batch); err != nil {
+			failedViewBatches.batches = batches[i:]
+			return err
+		}
+	}
+	failedViewBatches.batches = nil
+	return nil
+}
+
+func writeViews(batch viewBatch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Only documents that haven't seen the batch yet are updated. On a
+	// retry, the upsert for a daily document that already has the batch
+	// hits the unique index instead, which means it was counted.
+	notApplied := bson.M{"$ne": batch.id}
+	remember := bson.M{"$each": bson.A{batch.id}, "$slice": -viewBatchMemory}
+
+	totals := map[primitive.ObjectID]int64{}
+	var daily, referrers []mongo.WriteModel
+	for key, entry := range batch.pending {
+		totals[key.blogID] += entry.views
+		daily = append(daily, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"blog_id": key.blogID, "day": key.day, "batches": notApplied}).
+			SetUpdate(bson.M{"$inc": bson.M{"views": entry.views}, "$push": bson.M{"batches": remember}}).
+			SetUpsert(true))
+		for host, n := range entry.referrers {
+			referrers = append(referrers, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"blog_id": key.blogID, "day": key.day, "referrer": host, "batches": notApplied}).
+				SetUpdate(bson.M{"$inc": bson.M{"views": n}, "$push": bson.M{"batches": remember}}).
+				SetUpsert(true))
+		}
+	}
+
+	var blogs []mongo.WriteModel
+	for blogID, n := range totals {
+		blogs = append(blogs, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": blogID, "view_batches": notApplied}).
+			SetUpdate(bson.M{"$inc": bson.M{"view_count": n}, "$push": bson.M{"view_batches": remember}}))
+	}
+
+	unordered := options.BulkWrite().SetOrdered(false)
+	if _, err := getCollection("blog_views_daily").BulkWrite(ctx, daily, unordered); !onlyDuplicates(err) {
+		return err
+	}
+	if _, err := getCollection("blog_referrers_daily").BulkWrite(ctx, referrers, unordered); !onlyDuplicates(err) {
+		return err
+	}
+	_, err := getCollection("blogs").BulkWrite(ctx, blogs, unordered)
+	return err
+}
+
+// onlyDuplicates reports whether a bulk write succeeded apart from
+// duplicate key errors.
+func onlyDuplicates(err error) bool {
+	if err == nil {
+		return true
+	}
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
+
+// StartViewFlusher flushes buffered views every interval in the background.
+func StartViewFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := FlushViews(); err != nil {
+				log.Printf("Error flushing views: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package spam
+
+import (
+	"math"
+	"sync"
+)
+
+// Class is the label a classifier learns a document under.
+type Class int
+
+const (
+	Ham Class = iota
+	Spam
+)
+
+// Classifier is a naive Bayes text classifier over documents labelled Ham
+// or Spam. It is safe for concurrent use.
+type Classifier struct {
+	mu      sync.RWMutex
+	minDocs int
+	docs    [2]int
+	counts  [2]map[string]int
+	totals  [2]int
+	vocab   map[string]int
+}
+
+// NewClassifier returns an empty Classifier that only starts scoring once it
+// has learned at least minDocs documents of each class.
+func NewClassifier(minDocs int) *Classifier {
+	return &Classifier{
+		minDocs: minDocs,
+		counts:  [2]map[string]int{{}, {}},
+		vocab:   map[string]int{},
+	}
+}
+
+// Learn adds a document, given as its tokens, to class.
+func (c *Classifier) Learn(tokens []string, class Class) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.docs[class]++
+	for _, token := range tokens {
+		c.counts[class][token]++
+		c.totals[class]++
+		c.vocab[token]++
+	}
+}
+
+// Forget removes a document previously learned under class, for when a
+// moderator changes their mind about it.
+func (c *Classifier) Forget(tokens []string, class Class) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.docs[class] == 0 {
+		return
+	}
+	c.docs[class]--
+	for _, token := range tokens {
+		if c.counts[class][token] == 0 {
+			continue
+		}
+		c.counts[class][token]--
+		c.totals[class]--
+		if c.counts[class][token] == 0 {
+			delete(c.counts[class], token)
+		}
+		if c.vocab[token]--; c.vocab[token] <= 0 {
+			delete(c.vocab, token)
+		}
+	}
+}
+
+// SpamProbability returns the probability that a document with tokens is
+// spam. ok is false while the classifier has too little training to judge.
+func (c *Classifier) SpamProbability(tokens []string) (p float64, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.docs[Ham] < c.minDocs || c.docs[Spam] < c.minDocs || c.docs[Ham]+c.docs[Spam] == 0 {
+		return 0, false
+	}
+
+	total := float64(c.docs[Ham] + c.docs[Spam])
+	vocab := float64(len(c.vocab))
+	var logProb [2]float64
+	for _, class := range []Class{Ham, Spam} {
+		logProb[class] = math.Log(float64(c.docs[class]) / total)
+		denominator := float64(c.totals[class]) + vocab
+		for _, token := range tokens {
+			// Words never seen in training carry no evidence either way.
+			if c.vocab[token] == 0 {
+				continue
+			}
+			// Laplace smoothing keeps unseen words from zeroing a class out.
+			logProb[class] += math.Log((float64(c.counts[class][token]) + 1) / denominator)
+		}
+	}
+	return 1 / (1 + math.Exp(logProb[Ham]-logProb[Spam])), true
+}
@@ -0,0 +1,62 @@
+// Package spam holds the text analysis behind comment spam detection: a
+// tokenizer, link extraction and a naive Bayes classifier.
+package spam
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// Links returns every link in text, in order of appearance.
+func Links(text string) []string {
+	return linkPattern.FindAllString(text, -1)
+}
+
+// LinkHosts returns the lowercased host names of the links in text, without
+// a leading "www.".
+func LinkHosts(text string) []string {
+	var hosts []string
+	for _, link := range Links(text) {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		hosts = append(hosts, strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."))
+	}
+	return hosts
+}
+
+// Tokenize splits text into the distinct lowercased words the classifier
+// learns from. Link hosts become "host:<name>" tokens so that a domain is
+// recognised no matter which page on it is linked.
+func Tokenize(text string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	add := func(token string) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, host := range LinkHosts(text) {
+		add("host:" + host)
+	}
+	words := strings.FieldsFunc(linkPattern.ReplaceAllString(text, " "), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	for _, word := range words {
+		word = strings.ToLower(strings.Trim(word, "'"))
+		if n := len(word); n >= 2 && n <= 40 {
+			add(word)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,37 @@
+package templates
+
+// Samples holds example data for previewing each built-in email.
+var Samples = map[string]Data{
+	"welcome":        {"UserName": "Linda"},
+	"password_reset": {"ResetURL": "https://example.com/reset-password?token=sample-token"},
+	"blog_notification": {
+		"BlogTitle": "A Week in the Mountains", "BlogAuthor": "Ed", "Link": "https://example.com/blog/sample",
+		"Scope": "all", "Target": "", "UnsubscribeURL": "https://example.com/unsubscribe?token=sample-token",
+	},
+	"digest": {
+		"Delivery": "weekly", "Scope": "author", "Target": "Ed",
+		"UnsubscribeURL": "https://example.com/unsubscribe?token=sample-token",
+		"Posts": []map[string]string{
+			{"Title": "A Week in the Mountains", "Author": "Ed", "Link": "https://example.com/blog/sample"},
+			{"Title": "Back Home Again", "Author": "Ed", "Link": "https://example.com/blog/sample-2"},
+		},
+	},
+	"subscription_confirm": {
+		"Scope": "category", "Target": "Travel", "Delivery": "daily",
+		"ConfirmURL": "https://example.com/subscriptions/confirm?token=sample-token",
+	},
+	"moderation":           {"BlogTitle": "A Week in the Mountains", "CommenterName": "Sam", "ModerationURL": "https://example.com/moderation"},
+	"mention":              {"MentionedBy": "Sam", "BlogTitle": "A Week in the Mountains", "Link": "https://example.com/blog/sample"},
+	"notification":         {"Message": "Sam replied to your comment", "Link": "https://example.com/blog/sample"},
+	"verify_email":         {"UserName": "Linda", "VerificationURL": "https://example.com/verify-email?code=sample-code"},
+	"email_change_confirm": {"UserName": "Linda", "ConfirmURL": "https://example.com/confirm-email?token=sample-token"},
+	"invitation": {
+		"InviterName": "Ed", "Role": "Creator", "Message": "Come and write about the garden with us!",
+		"AcceptURL": "https://example.com/accept-invitation?token=sample-token", "ExpiresAt": "June 1, 2025 12:00 UTC",
+	},
+	"role_request_result": {
+		"UserName": "jane", "RequestedRole": "Creator", "Approved": true,
+		"Reason": "Welcome aboard, we look forward to your posts.",
+	},
+	"email_change_notice": {"UserName": "Linda", "NewEmail": "linda@example.org"},
+}
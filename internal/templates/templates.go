@@ -0,0 +1,272 @@
+// Package templates renders the site's emails from html/template and
+// text/template files. Each email lives in <locale>/<name>.tmpl and defines
+// a "subject", a "text" and an "html" template, which layout.tmpl wraps.
+// Snippets used by more than one email go in <locale>/partials.tmpl.
+// Files in an override directory with the same layout replace the built-in
+// ones.
+package templates
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+//go:embed emails
+var embedded embed.FS
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Email is a rendered email.
+type Email struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Data is what a template is rendered with.
+type Data map[string]interface{}
+
+type parsed struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer renders email templates, choosing the best locale available.
+type Renderer struct {
+	fsys          fs.FS
+	defaultLocale string
+	common        Data
+
+	mu    sync.Mutex
+	cache map[string]*parsed
+}
+
+// New returns a Renderer. Templates in overrideDir, if set, take precedence
+// over the built-in ones. common is merged into the data of every email.
+func New(overrideDir, defaultLocale string, common Data) *Renderer {
+	builtIn, _ := fs.Sub(embedded, "emails")
+	fsys := fs.FS(builtIn)
+	if overrideDir != "" {
+		fsys = overlayFS{os.DirFS(overrideDir), builtIn}
+	}
+	if defaultLocale == "" {
+		defaultLocale = "en"
+	}
+	return &Renderer{
+		fsys:          fsys,
+		defaultLocale: NormalizeLocale(defaultLocale),
+		common:        common,
+		cache:         make(map[string]*parsed),
+	}
+}
+
+// Render renders the named email in locale, falling back to the locale's
+// language and then to the default locale.
+func (r *Renderer) Render(name, locale string, data Data) (*Email, error) {
+	tmpl, err := r.load(name, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := Data{}
+	for key, value := range r.common {
+		merged[key] = value
+	}
+	for key, value := range data {
+		merged[key] = value
+	}
+
+	var subject, text, html strings.Builder
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", merged); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text_layout", merged); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "html_layout", merged); err != nil {
+		return nil, err
+	}
+	return &Email{
+		// A subject is a single header line.
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// Names lists the emails available in the default locale.
+func (r *Renderer) Names() []string {
+	entries, _ := fs.ReadDir(r.fsys, r.defaultLocale)
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".tmpl"); ok && !entry.IsDir() && name != "layout" && name != "partials" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Locales lists the locales the named email has a translation for.
+func (r *Renderer) Locales(name string) []string {
+	entries, _ := fs.ReadDir(r.fsys, ".")
+	var locales []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := fs.Stat(r.fsys, path.Join(entry.Name(), name+".tmpl")); err == nil {
+			locales = append(locales, entry.Name())
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// NormalizeLocale turns "pt_BR" into "pt-br".
+func NormalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// load parses the best match for name and locale, caching the result.
+func (r *Renderer) load(name, locale string) (*parsed, error) {
+	if !validName(name) {
+		return nil, ErrUnknownTemplate
+	}
+	locale = r.resolve(name, locale)
+	if locale == "" {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+
+	key := locale + "/" + name
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tmpl, ok := r.cache[key]; ok {
+		return tmpl, nil
+	}
+
+	layout, err := fs.ReadFile(r.fsys, path.Join(locale, "layout.tmpl"))
+	if errors.Is(err, fs.ErrNotExist) {
+		layout, err = fs.ReadFile(r.fsys, "layout.tmpl")
+	}
+	if err != nil {
+		return nil, err
+	}
+	partials, err := r.partials(locale)
+	if err != nil {
+		return nil, err
+	}
+	body, err := fs.ReadFile(r.fsys, key+".tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	text := texttemplate.New(name).Option("missingkey=error")
+	html := htmltemplate.New(name).Option("missingkey=error")
+	for _, src := range []string{string(layout), string(partials), string(body)} {
+		if text, err = text.Parse(src); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if html, err = html.Parse(src); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	tmpl := &parsed{text: text, html: html}
+	r.cache[key] = tmpl
+	return tmpl, nil
+}
+
+// partials reads the shared snippets for locale, falling back to the
+// default locale's. Having none is fine.
+func (r *Renderer) partials(locale string) ([]byte, error) {
+	for _, candidate := range []string{locale, r.defaultLocale} {
+		src, err := fs.ReadFile(r.fsys, path.Join(candidate, "partials.tmpl"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return src, err
+		}
+	}
+	return nil, nil
+}
+
+// resolve returns the locale to render name in, or "" if there is none.
+func (r *Renderer) resolve(name, locale string) string {
+	locale = NormalizeLocale(locale)
+	candidates := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, lang)
+	}
+	candidates = append(candidates, r.defaultLocale)
+	for _, candidate := range candidates {
+		if candidate == "" || !validName(candidate) {
+			continue
+		}
+		if _, err := fs.Stat(r.fsys, path.Join(candidate, name+".tmpl")); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// validName keeps template names and locales from reaching outside the
+// template directory.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// overlayFS looks files up in each layer in turn.
+type overlayFS []fs.FS
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir merges the directory listings of every layer.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	found := false
+	for _, layer := range o {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, entry := range layerEntries {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
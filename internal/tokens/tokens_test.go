@@ -0,0 +1,46 @@
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret, purpose, subject = "secret", "confirm", "user:42"
+	valid := Sign(secret, purpose, subject, time.Now().Add(time.Hour))
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := encoding.EncodeToString([]byte("0.user:43")) + "." + sig
+
+	tests := []struct {
+		name    string
+		secret  string
+		purpose string
+		token   string
+		want    string
+		wantErr error
+	}{
+		{"valid", secret, purpose, valid, subject, nil},
+		{"never expires", secret, purpose, Sign(secret, purpose, subject, time.Time{}), subject, nil},
+		{"subject with dots", secret, purpose, Sign(secret, purpose, "a.b.c", time.Time{}), "a.b.c", nil},
+		{"expired", secret, purpose, Sign(secret, purpose, subject, time.Now().Add(-time.Minute)), "", ErrExpired},
+		{"other purpose", secret, "reset", valid, "", ErrInvalid},
+		{"other secret", "other", purpose, valid, "", ErrInvalid},
+		{"changed subject", secret, purpose, forged, "", ErrInvalid},
+		{"no signature", secret, purpose, payload, "", ErrInvalid},
+		{"bad encoding", secret, purpose, "!!!." + sig, "", ErrInvalid},
+		{"empty", secret, purpose, "", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.secret, tt.purpose, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"goserver/internal/config"
 	"goserver/internal/database"
 	"goserver/internal/handlers"
+	"goserver/internal/mailer"
 	"goserver/internal/middleware"
+	"goserver/internal/realtime"
+	"goserver/internal/services"
+	"goserver/internal/templates"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,17 +25,115 @@ import (
 func main() {
 	// Load configuration
 	cfg := config.Load()
-	database.InitMongo(cfg.DatabaseURL)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := database.InitMongo(cfg.DatabaseURL); err != nil {
+		log.Fatalf("Failed to initialize MongoDB: %v", err)
+	}
+	if err := services.MigrateUserEmails(); err != nil {
+		log.Printf("Failed to migrate user emails: %v", err)
+	}
+	if err := services.EnsureIndexes(); errors.Is(err, services.ErrRequiredIndex) {
+		log.Fatalf("Failed to create indexes: %v", err)
+	} else if err != nil {
+		log.Printf("Failed to create indexes: %v", err)
+	}
+	if err := services.MigrateCommentThreads(); err != nil {
+		log.Printf("Failed to migrate comment threads: %v", err)
+	}
+	if err := services.MigrateNotificationEvents(); err != nil {
+		log.Printf("Failed to migrate notification events: %v", err)
+	}
+	if err := services.MigrateCommentCounts(); err != nil {
+		log.Printf("Failed to migrate comment counts: %v", err)
+	}
+	if err := services.MigrateAuthorSubscriptions(); err != nil {
+		log.Printf("Failed to migrate author subscriptions: %v", err)
+	}
+	services.RegisterSpamChecker(&services.LinkChecker{MaxLinks: cfg.SpamMaxLinks, BlockedDomains: cfg.SpamBlockedDomains}, cfg.SpamWeightLinks)
+	services.RegisterSpamChecker(&services.BayesChecker{}, cfg.SpamWeightBayes)
+	services.RegisterSpamChecker(&services.HoneypotChecker{}, cfg.SpamWeightHoneypot)
+	services.RegisterSpamChecker(&services.SubmitTimeChecker{MinDuration: cfg.SpamMinSubmitTime}, cfg.SpamWeightSubmitTime)
+	if err := services.TrainSpamClassifier(); err != nil {
+		log.Printf("Failed to train spam classifier: %v", err)
+	}
+
+	mail, err := mailer.New(mailer.Options{
+		Backend:        cfg.MailBackend,
+		SendGridAPIKey: cfg.SendGridAPIKey,
+		SMTPHost:       cfg.SMTPHost,
+		SMTPPort:       cfg.SMTPPort,
+		SMTPUsername:   cfg.SMTPUsername,
+		SMTPPassword:   cfg.SMTPPassword,
+		SMTPRequireTLS: cfg.SMTPRequireTLS,
+		Dir:            cfg.MailDir,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up mail: %v", err)
+	}
+	services.SetMailer(mail, cfg.MailFrom)
+	mailEvents, err := mailer.NewEventSources(mailer.EventOptions{
+		SendGridPublicKey: cfg.SendGridWebhookKey,
+		WebhookSecret:     cfg.MailWebhookSecret,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up mail webhooks: %v", err)
+	}
+	services.SetEmailTemplates(templates.New(cfg.EmailTemplateDir, cfg.DefaultLocale, templates.Data{
+		"SiteTitle": cfg.SiteTitle,
+		"SiteURL":   cfg.FrontendURL,
+	}))
+
+	services.SetLinkSecret(cfg.TokenSecret)
+	services.ConfigureSubscriptions(services.SubscriptionOptions{
+		APIURL: cfg.PublicAPIURL,
+	})
+
+	services.ConfigureMentionNotifications(cfg.MentionNotifyLimit, cfg.MentionNotifyWindow)
+	services.ConfigureRoleRequests(cfg.RoleRequestCooldown)
+	services.SetNotificationChannels(
+		&services.InAppChannel{},
+		&services.EmailChannel{},
+		services.NewWebhookChannel(),
+	)
+
+	realtime.InitBroker(cfg.StreamHistorySize, cfg.StreamBufferSize)
+	realtime.InitHub(services.AuthorizeChannel, realtime.SocketLimits{
+		MaxConnections:        cfg.SocketMaxConnections,
+		MaxConnectionsPerUser: cfg.SocketMaxConnectionsPerUser,
+		MaxSubscriptions:      cfg.SocketMaxSubscriptions,
+		MaxMessageSize:        cfg.SocketMaxMessageSize,
+		MessageLimit:          cfg.SocketMessageLimit,
+		MessageWindow:         cfg.SocketMessageWindow,
+		PingInterval:          cfg.SocketPingInterval,
+		PresenceTimeout:       cfg.SocketPresenceTimeout,
+	})
+
+	services.StartTrashPurger(cfg.TrashRetention, cfg.TrashPurgeInterval)
+	services.StartViewFlusher(cfg.ViewFlushInterval)
+	services.StartEmailWorkers(services.OutboxOptions{
+		Workers:      cfg.EmailWorkers,
+		MaxAttempts:  cfg.EmailMaxAttempts,
+		BaseBackoff:  cfg.EmailRetryBackoff,
+		MaxBackoff:   cfg.EmailMaxBackoff,
+		PollInterval: cfg.EmailPollInterval,
+	})
+	services.StartDigestScheduler(cfg.DigestCheckInterval)
 
 	// Initialize Gin router
-	router := gin.Default()
+	// Requests are logged by middleware.Logger, which keeps secrets out of
+	// the log
+	router := gin.New()
+	router.Use(gin.Recovery())
 
 	// Setup CORS
 	router.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 		c.Header("Access-Control-Allow-Origin", "http://localhost:3001")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, Last-Event-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Next-Cursor")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		log.Printf("CORS headers set for origin: %s", origin)
@@ -64,6 +172,17 @@ func main() {
 			apiRoutes.POST("/logout", authHandler.Logout)
 			apiRoutes.POST("/refresh", authHandler.RefreshToken)
 			apiRoutes.POST("/resend-verification", authHandler.ResendVerificationEmail)
+			apiRoutes.POST("/email-change", middleware.RequireAuth(), authHandler.RequestEmailChange)
+			apiRoutes.POST("/email-change/confirm", authHandler.ConfirmEmailChange)
+		}
+
+		// The caller's own account
+		meHandler := handlers.NewMeHandler()
+		meRoutes := api.Group("/me", middleware.RequireAuth())
+		{
+			meRoutes.GET("", meHandler.Get)
+			meRoutes.PATCH("", meHandler.Update)
+			meRoutes.POST("/password", meHandler.ChangePassword)
 		}
 
 		// Blog routes
@@ -77,13 +196,14 @@ func main() {
 		}
 
 		// Comment routes
-		commentHandler := handlers.NewCommentHandler()
+		commentHandler := handlers.NewCommentHandler(cfg)
 		commentRoutes := api.Group("/comments")
 		{
 			commentRoutes.GET("/:blogId", commentHandler.GetByBlogID)
+			commentRoutes.GET("/:blogId/form-token", commentHandler.FormToken)
 			commentRoutes.POST("/:blogId", middleware.RequireAuth(), middleware.RequireRole("Commentor", "Creator", "Admin"), commentHandler.Create)
-			commentRoutes.PUT("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"), commentHandler.Update)
-			commentRoutes.DELETE("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"), commentHandler.Delete)
+			commentRoutes.PUT("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Commentor", "Creator", "Admin"), commentHandler.Update)
+			commentRoutes.DELETE("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Commentor", "Creator", "Admin"), commentHandler.Delete)
 		}
 
 		userHandler := handlers.NewUserHandler()
@@ -95,8 +215,173 @@ func main() {
 			userRoutes.PUT("/:id", middleware.RequireAuth(), middleware.RequireRole("Admin"), userHandler.Update)
 			userRoutes.DELETE("/:id", middleware.RequireAuth(), middleware.RequireRole("Admin"), userHandler.Delete)
 		}
+
+		// Mention routes
+		mentionHandler := handlers.NewMentionHandler()
+		mentionRoutes := api.Group("/mentions", middleware.RequireAuth())
+		{
+			mentionRoutes.GET("/autocomplete", mentionHandler.Autocomplete)
+		}
+
+		// Notification routes
+		notificationHandler := handlers.NewNotificationHandler()
+		notificationRoutes := api.Group("/notifications", middleware.RequireAuth())
+		{
+			notificationRoutes.GET("", notificationHandler.List)
+			notificationRoutes.GET("/unread-count", notificationHandler.UnreadCount)
+			notificationRoutes.POST("/read-all", notificationHandler.MarkAllRead)
+			notificationRoutes.POST("/:id/read", notificationHandler.MarkRead)
+			notificationRoutes.GET("/preferences", notificationHandler.GetPreferences)
+			notificationRoutes.PUT("/preferences", notificationHandler.UpdatePreferences)
+			notificationRoutes.PUT("/webhook", notificationHandler.UpdateWebhook)
+			notificationRoutes.PUT("/locale", notificationHandler.UpdateLocale)
+		}
+
+		// Live update streams
+		streamHandler := handlers.NewStreamHandler(cfg)
+		streamRoutes := api.Group("/stream")
+		{
+			streamRoutes.GET("/blog/:id", streamHandler.Blog)
+			streamRoutes.GET("/posts", streamHandler.Posts)
+		}
+
+		// Real-time collaboration socket
+		socketHandler := handlers.NewSocketHandler(cfg)
+		api.GET("/ws", middleware.RequireSocketAuth(), socketHandler.Connect)
+		api.POST("/ws/ticket", middleware.RequireAuth(), socketHandler.Ticket)
+
+		// Moderation routes
+		moderationHandler := handlers.NewModerationHandler()
+		moderationRoutes := api.Group("/moderation", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"))
+		{
+			moderationRoutes.GET("/comments", moderationHandler.Queue)
+			moderationRoutes.POST("/comments/bulk", moderationHandler.Bulk)
+			moderationRoutes.POST("/comments/:id/:action", moderationHandler.Moderate)
+		}
+
+		// Reaction routes
+		reactionHandler := handlers.NewReactionHandler(cfg)
+		reactionRoutes := api.Group("/reactions")
+		{
+			reactionRoutes.GET("/:targetType/:targetId", reactionHandler.List)
+			reactionRoutes.PUT("/:targetType/:targetId/:type", middleware.OptionalAuth(), reactionHandler.Put)
+			reactionRoutes.DELETE("/:targetType/:targetId/:type", middleware.OptionalAuth(), reactionHandler.Delete)
+		}
+
+		// Analytics routes
+		analyticsHandler := handlers.NewAnalyticsHandler()
+		analyticsRoutes := api.Group("/analytics", middleware.RequireAuth())
+		{
+			analyticsRoutes.GET("/posts/:id", analyticsHandler.Post)
+			analyticsRoutes.GET("/me", middleware.RequireRole("Creator", "Admin"), analyticsHandler.Author)
+			analyticsRoutes.GET("/site", middleware.RequireRole("Admin"), analyticsHandler.Site)
+		}
+
+		// Author pages
+		authorHandler := handlers.NewAuthorHandler()
+		authorRoutes := api.Group("/authors")
+		{
+			authorRoutes.GET("/:username", authorHandler.Get)
+			authorRoutes.POST("/link-posts", middleware.RequireAuth(), middleware.RequireRole("Admin"), authorHandler.LinkPosts)
+		}
+
+		// Subscription routes
+		subscriptionHandler := handlers.NewSubscriptionHandler(cfg)
+		subscriptionRoutes := api.Group("/subscriptions")
+		{
+			subscriptionRoutes.POST("", middleware.OptionalAuth(), subscriptionHandler.Create)
+			subscriptionRoutes.GET("", middleware.RequireAuth(), subscriptionHandler.List)
+			subscriptionRoutes.PUT("/:id", middleware.RequireAuth(), subscriptionHandler.Update)
+			subscriptionRoutes.DELETE("/:id", middleware.RequireAuth(), subscriptionHandler.Delete)
+			subscriptionRoutes.POST("/confirm", subscriptionHandler.Confirm)
+			subscriptionRoutes.POST("/unsubscribe", subscriptionHandler.Unsubscribe)
+		}
+
+		// Invitation routes. Only Admins invite people, with a role no higher
+		// than their own.
+		invitationHandler := handlers.NewInvitationHandler(cfg)
+		invitationRoutes := api.Group("/invitations")
+		{
+			inviters := invitationRoutes.Group("", middleware.RequireAuth(), middleware.RequireRole("Admin"))
+			inviters.POST("", invitationHandler.Create)
+			inviters.GET("", invitationHandler.List)
+			inviters.POST("/:id/resend", invitationHandler.Resend)
+			inviters.DELETE("/:id", invitationHandler.Revoke)
+			invitationRoutes.GET("/accept", invitationHandler.Lookup)
+			invitationRoutes.POST("/accept", invitationHandler.Accept)
+		}
+
+		// Role request routes. Users ask for a higher role and admins decide.
+		roleRequestHandler := handlers.NewRoleRequestHandler()
+		roleRequestRoutes := api.Group("/role-requests", middleware.RequireAuth())
+		{
+			roleRequestRoutes.POST("", roleRequestHandler.Create)
+			roleRequestRoutes.GET("/mine", roleRequestHandler.Mine)
+			roleRequestRoutes.GET("", middleware.RequireRole("Admin"), roleRequestHandler.List)
+			roleRequestRoutes.POST("/:id/approve", middleware.RequireRole("Admin"), roleRequestHandler.Approve)
+			roleRequestRoutes.POST("/:id/deny", middleware.RequireRole("Admin"), roleRequestHandler.Deny)
+		}
+
+		// Email outbox routes
+		outboxHandler := handlers.NewOutboxHandler()
+		outboxRoutes := api.Group("/outbox", middleware.RequireAuth(), middleware.RequireRole("Admin"))
+		{
+			outboxRoutes.GET("/emails", outboxHandler.List)
+			outboxRoutes.GET("/emails/:id", outboxHandler.Get)
+			outboxRoutes.POST("/emails/retry-dead", outboxHandler.RetryDead)
+			outboxRoutes.POST("/emails/:id/retry", outboxHandler.Retry)
+			outboxRoutes.GET("/suppressions", outboxHandler.Suppressions)
+			outboxRoutes.DELETE("/suppressions/:email", outboxHandler.Unsuppress)
+		}
+
+		// Delivery events from mail providers, verified by signature
+		mailEventHandler := handlers.NewMailEventHandler(mailEvents)
+		api.POST("/mail/events/:provider", mailEventHandler.Receive)
+
+		// Audit log
+		auditHandler := handlers.NewAuditHandler()
+		api.GET("/audit", middleware.RequireAuth(), middleware.RequireRole("Admin"), auditHandler.List)
+
+		// Email template previews
+		emailTemplateHandler := handlers.NewEmailTemplateHandler()
+		emailTemplateRoutes := api.Group("/email-templates", middleware.RequireAuth(), middleware.RequireRole("Admin"))
+		{
+			emailTemplateRoutes.GET("", emailTemplateHandler.List)
+			emailTemplateRoutes.GET("/:name/preview", emailTemplateHandler.Preview)
+			emailTemplateRoutes.POST("/:name/preview", emailTemplateHandler.Preview)
+		}
+
+		// Trash routes
+		trashHandler := handlers.NewTrashHandler()
+		trashRoutes := api.Group("/trash", middleware.RequireAuth(), middleware.RequireRole("Admin"))
+		{
+			trashRoutes.GET("/blogs", trashHandler.ListBlogs)
+			trashRoutes.GET("/comments", trashHandler.ListComments)
+			trashRoutes.GET("/users", trashHandler.ListUsers)
+			trashRoutes.POST("/blogs/:id/restore", trashHandler.RestoreBlog)
+			trashRoutes.POST("/comments/:id/restore", trashHandler.RestoreComment)
+			trashRoutes.POST("/users/:id/restore", trashHandler.RestoreUser)
+		}
 	}
 
+	// Syndication feeds
+	feedHandler := handlers.NewFeedHandler(cfg)
+	router.GET("/feed.rss", feedHandler.RSS)
+	router.GET("/feed.atom", feedHandler.Atom)
+	router.GET("/feed.json", feedHandler.JSON)
+	router.GET("/category/:category/feed.rss", feedHandler.RSS)
+	router.GET("/category/:category/feed.atom", feedHandler.Atom)
+	router.GET("/category/:category/feed.json", feedHandler.JSON)
+	router.GET("/author/:author/feed.rss", feedHandler.RSS)
+	router.GET("/author/:author/feed.atom", feedHandler.Atom)
+	router.GET("/author/:author/feed.json", feedHandler.JSON)
+
+	// Crawlers
+	seoHandler := handlers.NewSEOHandler(cfg)
+	router.GET("/sitemap.xml", seoHandler.Sitemap)
+	router.GET("/sitemaps/:page", seoHandler.SitemapPage)
+	router.GET("/robots.txt", seoHandler.Robots)
+
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -107,6 +392,30 @@ func main() {
 		port = "3003"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(router.Run(":" + port))
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	// Shutdown waits for requests to finish, and event streams never do on
+	// their own
+	server.RegisterOnShutdown(realtime.Shutdown)
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop on SIGINT or SIGTERM, finishing in-flight requests and writing
+	// out buffered views first
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	if err := services.FlushViews(); err != nil {
+		log.Printf("Error flushing views: %v", err)
+	}
 }